@@ -0,0 +1,70 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+func printAgeHistogram(h *scan.AgeHistogram, flatUnits bool, indent string) {
+	for i, b := range h {
+		size := statticker.FormatBytes(b.Usage(useAllocated))
+		if flatUnits {
+			size = fmt.Sprint(b.Usage(useAllocated))
+		}
+		fmt.Printf("%s%-6s %12s %10s\n", indent, scan.AgeBucketNames[i], size, statticker.AddCommas(b.Files))
+	}
+}
+
+// byAgeUsage orders the keys of per user or per directory histograms by
+// their total usage, largest first
+func byAgeUsage[K cmp.Ordered](m map[K]scan.AgeHistogram) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	usage := func(k K) uint64 {
+		h := m[k]
+		t := h.Total()
+		return t.Usage(useAllocated)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		if c := cmp.Compare(usage(b), usage(a)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return keys
+}
+
+func printAgeInfo(res *scan.Result, limit int, flatUnits bool) {
+	fmt.Printf("file usage by %s age\n", res.Options.TimeField)
+	fmt.Printf("%-6s %12s %10s\n", "Age", "Space", "Files")
+	printAgeHistogram(&res.Ages, flatUnits, "")
+	fmt.Println()
+	if !isWindows {
+		fmt.Printf("file usage by %s age per user id\n", res.Options.TimeField)
+		for i, uid := range byAgeUsage(res.AgesByUser) {
+			if i >= limit {
+				break
+			}
+			fmt.Printf("%s (uid %d)\n", idNames.User(uid), uid)
+			h := res.AgesByUser[uid]
+			printAgeHistogram(&h, flatUnits, "    ")
+		}
+		fmt.Println()
+	}
+	fmt.Printf("file usage by %s age per top-level directory\n", res.Options.TimeField)
+	for i, top := range byAgeUsage(res.AgesByTopDir) {
+		if i >= limit {
+			break
+		}
+		fmt.Println(top)
+		h := res.AgesByTopDir[top]
+		printAgeHistogram(&h, flatUnits, "    ")
+	}
+	fmt.Println()
+}
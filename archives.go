@@ -0,0 +1,35 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+// printArchiveSummary lists the largest archives read as directories with
+// their size on disk next to what they hold uncompressed
+func printArchiveSummary(list []scan.ArchiveInfo, limit int, flatUnits bool) {
+	list = slices.Clone(list)
+	slices.SortFunc(list, func(a, b scan.ArchiveInfo) int { return cmp.Compare(b.Compressed, a.Compressed) })
+	fmt.Println("Archives by compressed size")
+	fmt.Printf("%12s %12s %6s %12s %-8s %s\n", "Compressed", "Uncompressed", "Ratio", "Files", "Format", "Archive")
+	for i, a := range list {
+		if i >= limit {
+			fmt.Printf("... and %d more archives\n", len(list)-limit)
+			break
+		}
+		ratio := 0.0
+		if a.Size > 0 {
+			ratio = float64(a.Compressed) / float64(a.Size)
+		}
+		if flatUnits {
+			fmt.Printf("%12d %12d %6.3f %12d %-8s %s\n", a.Compressed, a.Size, ratio, a.Files, a.Format, a.Path)
+		} else {
+			fmt.Printf("%12s %12s %5.1f%% %12s %-8s %s\n", statticker.FormatBytes(a.Compressed), statticker.FormatBytes(a.Size),
+				ratio*100, statticker.AddCommas(a.Files), a.Format, a.Path)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+)
+
+func printIncompleteBanner(reason error) {
+	fmt.Printf("*** INCOMPLETE SCAN: %v - all totals below only cover what was read ***\n", reason)
+}
+
+// printUnfinished lists the directories that were not read - parents of these
+// are missing the subtrees in their recursive totals
+func printUnfinished(list []string, limit int) {
+	fmt.Printf("%d directories not finished\n", len(list))
+	for i, p := range list {
+		if limit > 0 && i >= limit {
+			fmt.Printf("   ... and %d more\n", len(list)-limit)
+			break
+		}
+		fmt.Printf("   %s\n", p)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+const (
+	cleanDelete = "delete"
+	cleanTrash  = "trash"
+)
+
+// cleanupCriteria must all match - directories are only ever candidates
+// through a pattern, age and size alone only select files
+type cleanupCriteria struct {
+	olderThan  time.Duration
+	largerThan uint64
+	patterns   scan.PatternList
+}
+
+func (c *cleanupCriteria) isSet() bool {
+	return c.olderThan > 0 || c.largerThan > 0 || len(c.patterns) > 0
+}
+
+// cleanupFiles collects the files that could match the criteria as the
+// OnFile hook of the scan, so nothing the scan skipped - other filesystems,
+// excluded mounts or paths - can ever become a candidate
+type cleanupFiles struct {
+	crit   *cleanupCriteria
+	cutoff int64
+	mtx    sync.Mutex
+	paths  []string
+}
+
+func newCleanupFiles(crit *cleanupCriteria, now time.Time) *cleanupFiles {
+	return &cleanupFiles{crit: crit, cutoff: now.Add(-crit.olderThan).Unix()}
+}
+
+func (f *cleanupFiles) onFile(path string, info fs.FileInfo, depth int) {
+	crit := f.crit
+	if len(crit.patterns) > 0 && !crit.patterns.Matches(info.Name(), path) {
+		return
+	}
+	if crit.olderThan > 0 && info.ModTime().Unix() > f.cutoff {
+		return
+	}
+	// allocated sizes are only known once measured
+	if crit.largerThan > 0 && !useAllocated && uint64(info.Size()) < crit.largerThan {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.paths = append(f.paths, path)
+}
+
+// matchesDir checks a directory matching a pattern against the age and size
+func (c *cleanupCriteria) matchesDir(dir *scan.DirInfo, cutoff int64) bool {
+	if c.olderThan > 0 && dir.RecNewest != math.MinInt64 && dir.RecNewest > cutoff {
+		return false
+	}
+	return c.largerThan == 0 || dir.RecUsage(useAllocated) >= c.largerThan
+}
+
+// removalUsage is the size -clean-larger compares - the same for files and
+// directories
+func removalUsage(m *scan.Removal) uint64 {
+	if useAllocated {
+		return m.Totals.Allocated
+	}
+	return m.Totals.Size
+}
+
+// findCleanupCandidates lists what matches in the scanned tree - directories
+// matching a pattern and the files collected during the scan outside of them.
+// A directory holding anything the scan did not count is left alone, as
+// removing it would remove that too.
+func findCleanupCandidates(ctx context.Context, res *scan.Result, files *cleanupFiles) ([]*scan.Removal, error) {
+	crit := files.crit
+	if a := archiveOf(res, res.Root.Name); a != "" {
+		return nil, fmt.Errorf("%s is an archive - nothing in it can be cleaned", a)
+	}
+	// archives read as directories are left alone and so is everything in
+	// them or in a chosen directory
+	hidden := map[string]bool{}
+	for _, a := range res.Archives {
+		hidden[a.Path] = true
+	}
+	var paths []string
+	if len(crit.patterns) > 0 {
+		var walk func(dir *scan.DirInfo)
+		walk = func(dir *scan.DirInfo) {
+			for _, child := range dir.Children {
+				switch {
+				case hidden[child.Name]:
+				case crit.patterns.Matches(filepath.Base(child.Name), child.Name) && crit.matchesDir(child, files.cutoff):
+					hidden[child.Name] = true
+					paths = append(paths, child.Name)
+				default:
+					walk(child)
+				}
+			}
+		}
+		walk(res.Root)
+	}
+	for _, p := range files.paths {
+		if !isHidden(p, res.Root.Name, hidden) {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+
+	var list []*scan.Removal
+	for _, p := range paths {
+		m, err := res.Measure(ctx, p)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "not cleaning %s: %v\n", p, err)
+			continue
+		}
+		if m.Skipped > 0 {
+			fmt.Fprintf(os.Stderr, "not cleaning %s: %d entries below it were not scanned\n", p, m.Skipped)
+			continue
+		}
+		if crit.largerThan > 0 && removalUsage(m) < crit.largerThan {
+			continue
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+// archiveOf is the archive read as a directory that path is or is in, or ""
+// when it is on disk.  Paths in an archive do not exist to be removed.
+func archiveOf(res *scan.Result, path string) string {
+	for _, a := range res.Archives {
+		if scan.Within(path, a.Path) {
+			return a.Path
+		}
+	}
+	return ""
+}
+
+// isHidden is true when path or a directory above it, up to root, is hidden
+func isHidden(path string, root string, hidden map[string]bool) bool {
+	for p := path; p != root && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if hidden[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func printCleanupPreview(list []*scan.Removal, action string, confirmed bool) {
+	var size, files, dirs uint64
+	if confirmed {
+		fmt.Printf("will %s:\n", action)
+	} else {
+		fmt.Printf("DRY RUN - would %s:\n", action)
+	}
+	for _, c := range list {
+		kind := "file"
+		if c.IsDir {
+			kind = "dir "
+		}
+		fmt.Printf("%8s %s %10s files  %s\n", statticker.FormatBytes(c.Totals.Size), kind, statticker.AddCommas(c.Totals.Files), c.Path)
+		size += c.Totals.Size
+		files += c.Totals.Files
+		dirs += c.Totals.Dirs
+	}
+	fmt.Printf("total %s in %s files and %s directories from %d entries\n",
+		statticker.FormatBytes(size), statticker.AddCommas(files), statticker.AddCommas(dirs), len(list))
+}
+
+// trashDir follows the freedesktop layout so desktop trash tools can restore
+func trashDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+func moveToTrash(path string, now time.Time) error {
+	trash, err := trashDir()
+	if err != nil {
+		return err
+	}
+	filesDir, infoDir := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0700); err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(filesDir, name)); os.IsNotExist(err) {
+			break
+		}
+		name = filepath.Base(path) + "." + strconv.Itoa(i)
+	}
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", path, now.Format("2006-01-02T15:04:05"))
+	if err := os.WriteFile(filepath.Join(infoDir, name+".trashinfo"), []byte(info), 0600); err != nil {
+		return err
+	}
+	// rename only works within a filesystem - no copying of huge trees
+	if err := os.Rename(path, filepath.Join(filesDir, name)); err != nil {
+		os.Remove(filepath.Join(infoDir, name+".trashinfo"))
+		return err
+	}
+	return nil
+}
+
+// auditCleanup appends one tab separated line per action to the audit log
+func auditCleanup(logName string, action string, c *scan.Removal, result error, now time.Time) error {
+	f, err := os.OpenFile(logName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	status := "ok"
+	if result != nil {
+		status = "error: " + strings.ReplaceAll(result.Error(), "\t", " ")
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", now.Format(time.RFC3339), action,
+		c.Totals.Size, c.Totals.Files, c.Totals.Dirs, strconv.Quote(c.Path), status)
+	return err
+}
+
+// applyCleanup removes one candidate, logs it and takes it out of the result
+func applyCleanup(res *scan.Result, c *scan.Removal, action string, logName string) error {
+	now := time.Now()
+	var err error
+	if action == cleanTrash {
+		err = moveToTrash(c.Path, now)
+	} else {
+		err = os.RemoveAll(c.Path)
+	}
+	if logErr := auditCleanup(logName, action, c, err, now); logErr != nil && err == nil {
+		err = fmt.Errorf("removed but audit log failed: %w", logErr)
+	}
+	if err == nil || !pathExists(c.Path) {
+		res.Remove(c)
+	}
+	return err
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// runCleanup always previews and only acts when confirmed with -clean-yes
+func runCleanup(ctx context.Context, res *scan.Result, files *cleanupFiles, action string, confirmed bool, logName string) error {
+	list, err := findCleanupCandidates(ctx, res, files)
+	if err != nil {
+		return err
+	}
+	printCleanupPreview(list, action, confirmed)
+	if !confirmed {
+		fmt.Println("nothing removed - rerun with -clean-yes to", action)
+		return nil
+	}
+	failed := 0
+	for _, c := range list {
+		if err := applyCleanup(res, c, action, logName); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "failed to %s %s: %v\n", action, c.Path, err)
+		}
+	}
+	fmt.Printf("%s done: %d entries, %d failed - see %s\n", action, len(list)-failed, failed, logName)
+	return nil
+}
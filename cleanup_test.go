@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func candidatePaths(list []*scan.Removal) []string {
+	var paths []string
+	for _, c := range list {
+		paths = append(paths, c.Path)
+	}
+	return paths
+}
+
+func cleanupPatterns(t *testing.T, patterns ...string) scan.PatternList {
+	t.Helper()
+	var list scan.PatternList
+	for _, p := range patterns {
+		if err := list.Set(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return list
+}
+
+// only what the scan counted can be a candidate
+func TestCleanupCandidatesFollowScanFilters(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/logs/a.log", scan.MemFile{Size: 10})
+	fsys.Add("/r/logs/b.txt", scan.MemFile{Size: 10})
+	fsys.Add("/r/keep/c.log", scan.MemFile{Size: 10})
+	fsys.Add("/r/nfs/d.log", scan.MemFile{Size: 10})
+	fsys.Add("/r/cache/e.log", scan.MemFile{Size: 10})
+	fsys.Add("/r/cache/f", scan.MemFile{Size: 10})
+	fsys.Add("/r/x/cache/g", scan.MemFile{Size: 10})
+	fsys.Add("/r/x/cache/keep/h", scan.MemFile{Size: 10})
+	crit := &cleanupCriteria{patterns: cleanupPatterns(t, "*.log", "cache")}
+	files := newCleanupFiles(crit, time.Now())
+	res := scanMemFS(t, fsys, scan.Options{
+		Exclude:        cleanupPatterns(t, "keep"),
+		Mounts:         map[string]*scan.MountInfo{"/r/nfs": {MountPoint: "/r/nfs", FsType: "nfs"}},
+		ExcludeFsTypes: map[string]bool{"nfs": true},
+		Hooks:          scan.Hooks{OnFile: files.onFile},
+	})
+	list, err := findCleanupCandidates(context.Background(), res, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// /r/x/cache holds an excluded directory so it cannot go as a whole
+	want := []string{"/r/cache", "/r/logs/a.log"}
+	if got := candidatePaths(list); !slices.Equal(got, want) {
+		t.Errorf("candidates %v want %v", got, want)
+	}
+	if c := list[0]; !c.IsDir || c.Totals.Size != 20 || c.Totals.Files != 2 || c.Totals.Dirs != 1 {
+		t.Errorf("cache dir %+v", c)
+	}
+}
+
+// -clean-larger compares the same size for files and directories
+func TestCleanupLargerUsesOneSize(t *testing.T) {
+	defer func(a bool) { useAllocated = a }(useAllocated)
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/sparse", scan.MemFile{Size: 10000, Allocated: 4096})
+	fsys.Add("/r/dense", scan.MemFile{Size: 3000, Allocated: 8192})
+	fsys.Add("/r/d/sparse", scan.MemFile{Size: 10000, Allocated: 4096})
+	fsys.Add("/r/e/dense", scan.MemFile{Size: 3000, Allocated: 8192})
+	for _, c := range []struct {
+		allocated bool
+		want      []string
+	}{
+		{false, []string{"/r/d", "/r/sparse"}},
+		{true, []string{"/r/dense", "/r/e"}},
+	} {
+		useAllocated = c.allocated
+		// directories only become candidates through a pattern
+		crit := &cleanupCriteria{largerThan: 5000, patterns: cleanupPatterns(t, "d", "e", "*sparse", "*dense")}
+		files := newCleanupFiles(crit, time.Now())
+		res := scanMemFS(t, fsys, scan.Options{Allocated: c.allocated, Hooks: scan.Hooks{OnFile: files.onFile}})
+		list, err := findCleanupCandidates(context.Background(), res, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := candidatePaths(list); !slices.Equal(got, c.want) {
+			t.Errorf("allocated %v: candidates %v want %v", c.allocated, got, c.want)
+		}
+	}
+}
+
+// a real cleanup removes the files and takes them out of the reports
+func TestRunCleanup(t *testing.T) {
+	root := t.TempDir()
+	for name, size := range map[string]int{"a/x.tmp": 3000, "a/y": 100, "b/z.tmp": 2000, "b/keep": 50} {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crit := &cleanupCriteria{patterns: cleanupPatterns(t, "*.tmp")}
+	files := newCleanupFiles(crit, time.Now())
+	res, err := scan.New(scan.Options{TopN: 10, Hooks: scan.Hooks{OnFile: files.onFile}}).Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logName := filepath.Join(t.TempDir(), "audit.log")
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	err = runCleanup(context.Background(), res, files, cleanDelete, true, logName)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/x.tmp", "b/z.tmp"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still there: %v", name, err)
+		}
+	}
+	if res.Totals.Size != 150 || res.Totals.Files != 2 || res.Root.RecSize != 150 {
+		t.Errorf("totals %+v root %d", res.Totals, res.Root.RecSize)
+	}
+	if len(res.LargestFiles) != 2 || res.LargestFiles[0].Path != filepath.Join(root, "a/y") {
+		t.Errorf("largest files %v", res.LargestFiles)
+	}
+	if log, err := os.ReadFile(logName); err != nil || len(log) == 0 {
+		t.Errorf("audit log %q %v", log, err)
+	}
+}
+
+// nothing read out of an archive can be removed, from the command line or
+// the browser
+func TestCleanupSkipsArchives(t *testing.T) {
+	root := t.TempDir()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "in/x.tmp", Typeflag: tar.TypeReg, Size: 5000, Mode: 0644})
+	tw.Write(make([]byte, 5000))
+	tw.Close()
+	tarPath := filepath.Join(root, "b.tar")
+	if err := os.WriteFile(tarPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "y.tmp"), make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	crit := &cleanupCriteria{patterns: cleanupPatterns(t, "*.tmp", "in")}
+	files := newCleanupFiles(crit, time.Now())
+	res, err := scan.New(scan.Options{TopN: 10, Archives: true, Hooks: scan.Hooks{OnFile: files.onFile}}).Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := findCleanupCandidates(context.Background(), res, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := candidatePaths(list); !slices.Equal(got, []string{filepath.Join(root, "y.tmp")}) {
+		t.Errorf("candidates %v", got)
+	}
+
+	inside := filepath.Join(tarPath, "in", "x.tmp")
+	for _, path := range []string{inside, tarPath} {
+		b := &browser{res: res, showLargest: true, largest: []scan.PathSize{{Path: path}}}
+		b.previewCleanup("delete")
+		if b.pending != nil || !strings.Contains(b.status, "archive") {
+			t.Errorf("%s: pending %v status %q", path, b.pending, b.status)
+		}
+	}
+
+	files = newCleanupFiles(crit, time.Now())
+	if res, err = scan.New(scan.Options{Hooks: scan.Hooks{OnFile: files.onFile}}).Scan(context.Background(), tarPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findCleanupCandidates(context.Background(), res, files); err == nil {
+		t.Error("cleaning inside an archive root")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// apiClient talks to a -serve instance - it is what the "client" sub command
+// uses and stands in for downstream consumers in tests
+type apiClient struct {
+	base string
+	http *http.Client
+}
+
+func newApiClient(base string) *apiClient {
+	return &apiClient{base: strings.TrimSuffix(base, "/"), http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *apiClient) get(p string, query url.Values, out any) error {
+	u := c.base + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := c.http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s: %s %s", p, resp.Status, apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *apiClient) Status() (*apiStatus, error) {
+	var status apiStatus
+	return &status, c.get("/api/v1/status", nil, &status)
+}
+
+func (c *apiClient) Tree(dir string) (*apiTree, error) {
+	var tree apiTree
+	query := url.Values{}
+	if dir != "" {
+		query.Set("path", dir)
+	}
+	return &tree, c.get("/api/v1/tree", query, &tree)
+}
+
+func (c *apiClient) Top(report string, limit int) ([]jsonPathSize, error) {
+	var list []jsonPathSize
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", fmt.Sprint(limit))
+	}
+	return list, c.get("/api/v1/top/"+url.PathEscape(report), query, &list)
+}
+
+func (c *apiClient) Users() ([]jsonUser, error) {
+	var users []jsonUser
+	return users, c.get("/api/v1/users", nil, &users)
+}
+
+// clientMain is the "client" sub command: du client [-url URL] status|users|top REPORT|tree [PATH]
+func clientMain(args []string) int {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	base := flags.String("url", "http://localhost:8080", "base url of the -serve instance")
+	limit := flags.Int("l", 0, "limit top reports to N entries")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s client [OPTIONS] status|users|top REPORT|tree [PATH]\n", path.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	c := newApiClient(*base)
+	var out any
+	var err error
+	switch flags.Arg(0) {
+	case "status":
+		out, err = c.Status()
+	case "users":
+		out, err = c.Users()
+	case "top":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return 2
+		}
+		out, err = c.Top(flags.Arg(1), *limit)
+	case "tree":
+		out, err = c.Tree(flags.Arg(1))
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 3
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+	return 0
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+// recordWriter is what encoding/csv.Writer gives us - nulWriter has the same shape
+type recordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// nulWriter follows every field with a NUL and does no quoting at all.  Paths
+// can hold anything but NUL so split on NUL and take the column count per row.
+type nulWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (n *nulWriter) Write(record []string) error {
+	for _, field := range record {
+		if n.err != nil {
+			return n.err
+		}
+		if _, n.err = n.w.WriteString(field); n.err == nil {
+			n.err = n.w.WriteByte(0)
+		}
+	}
+	return n.err
+}
+
+func (n *nulWriter) Flush() {
+	if n.err == nil {
+		n.err = n.w.Flush()
+	}
+}
+
+func (n *nulWriter) Error() error {
+	return n.err
+}
+
+func newRecordWriter(w io.Writer, delim string) (recordWriter, error) {
+	switch delim {
+	case "comma":
+		return csv.NewWriter(w), nil
+	case "tab":
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return cw, nil
+	case "nul":
+		return &nulWriter{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown delimiter '%s' - must be comma, tab or nul", delim)
+}
+
+// csvColumn is one selectable -D column - time columns get a _days suffix in
+// the header with flat units since they are then fractional days
+type csvColumn struct {
+	name   string
+	isTime bool
+	value  func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string
+}
+
+func csvBytes(v uint64, flatUnits bool) string {
+	if flatUnits {
+		return strconv.FormatUint(v, 10)
+	}
+	return statticker.FormatBytes(v)
+}
+
+func csvAge(t int64, start *time.Time, flatUnits bool) string {
+	if flatUnits {
+		return mod2TimestampStr(t, start)
+	}
+	return mod2str(t, start)
+}
+
+var csvColumns = []csvColumn{
+	{"path", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string { return dir.Name }},
+	{"imm_size", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvBytes(dir.ImmUsage(useAllocated), flatUnits)
+	}},
+	{"imm_files", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return strconv.FormatUint(dir.ImmFiles, 10)
+	}},
+	{"imm_dirs", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return strconv.FormatUint(dir.ImmDirs, 10)
+	}},
+	{"rec_size", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvBytes(dir.RecUsage(useAllocated), flatUnits)
+	}},
+	{"rec_files", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return strconv.FormatUint(dir.RecFiles, 10)
+	}},
+	{"rec_dirs", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return strconv.FormatUint(dir.RecDirs, 10)
+	}},
+	{"imm_oldest", true, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvAge(dir.ImmOldest, start, flatUnits)
+	}},
+	{"imm_newest", true, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvAge(dir.ImmNewest, start, flatUnits)
+	}},
+	{"rec_oldest", true, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvAge(dir.RecOldest, start, flatUnits)
+	}},
+	{"rec_newest", true, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvAge(dir.RecNewest, start, flatUnits)
+	}},
+	{"depth", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return strconv.Itoa(depth)
+	}},
+	// not in the default set
+	{"imm_allocated", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvBytes(dir.ImmBlocks, flatUnits)
+	}},
+	{"rec_allocated", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvBytes(dir.RecBlocks, flatUnits)
+	}},
+	{"rec_shared", false, func(dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) string {
+		return csvBytes(dir.RecShared, flatUnits)
+	}},
+}
+
+const defaultCsvColumns = "path,imm_size,imm_files,imm_dirs,rec_size,rec_files,rec_dirs,imm_oldest,imm_newest,rec_oldest,rec_newest,depth"
+
+func selectCsvColumns(list string) ([]csvColumn, error) {
+	var cols []csvColumn
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(csvColumns, func(c csvColumn) bool { return c.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column '%s'", name)
+		}
+		cols = append(cols, csvColumns[i])
+	}
+	return cols, nil
+}
+
+func csvHeader(cols []csvColumn, flatUnits bool) []string {
+	header := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if c.isTime && flatUnits {
+			header = append(header, c.name+"_days")
+		} else {
+			header = append(header, c.name)
+		}
+	}
+	return header
+}
+
+func treeWalkDetails(w recordWriter, cols []csvColumn, dir *scan.DirInfo, depth int, start *time.Time, flatUnits bool) {
+	if depth == 0 {
+		w.Write(csvHeader(cols, flatUnits))
+	}
+	record := make([]string, len(cols))
+	for i, c := range cols {
+		record[i] = c.value(dir, depth, start, flatUnits)
+	}
+	w.Write(record)
+	for _, child := range dir.Children {
+		treeWalkDetails(w, cols, child, depth+1, start, flatUnits)
+	}
+}
+
+func csvSummary(w recordWriter, list []scan.PathSize, report string) {
+	for i, value := range list {
+		w.Write([]string{report, strconv.Itoa(i + 1), value.Path, strconv.FormatInt(value.Size, 10), "", ""})
+	}
+}
+
+func csvUsers(w recordWriter, res *scan.Result, limit int) {
+	for i, u := range res.UserList() {
+		if i >= limit {
+			break
+		}
+		w.Write([]string{"users", strconv.Itoa(i + 1), idNames.User(u.Uid),
+			strconv.FormatUint(u.Usage(useAllocated), 10), strconv.FormatUint(u.Files, 10), strconv.FormatUint(u.Dirs, 10)})
+	}
+}
+
+func csvGroups(w recordWriter, res *scan.Result, limit int) {
+	for i, g := range res.GroupList() {
+		if i >= limit {
+			break
+		}
+		w.Write([]string{"groups", strconv.Itoa(i + 1), idNames.Group(g.Gid),
+			strconv.FormatUint(g.Usage(useAllocated), 10), strconv.FormatUint(g.Files, 10), strconv.FormatUint(g.Dirs, 10)})
+	}
+}
+
+// csvUserTops keys each entry as user:path
+func csvUserTops(w recordWriter, res *scan.Result) {
+	for _, u := range res.UserList() {
+		top, ok := res.UserTops[u.Uid]
+		if !ok {
+			continue
+		}
+		uid := idNames.User(u.Uid) + ":"
+		for i, v := range top.LargestFiles {
+			w.Write([]string{"user_largest_files", strconv.Itoa(i + 1), uid + v.Path, strconv.FormatInt(v.Size, 10), "", ""})
+		}
+		for i, v := range top.DirsByOwnedSize {
+			w.Write([]string{"user_dirs_by_owned_size", strconv.Itoa(i + 1), uid + v.Path, strconv.FormatInt(v.Size, 10), "", ""})
+		}
+	}
+}
+
+// csvKinds puts the category after the extension in the key as ext:category
+func csvKinds(w recordWriter, res *scan.Result, limit int) {
+	for i, k := range scan.GroupKinds(res.Kinds, true, useAllocated) {
+		if i >= limit {
+			break
+		}
+		w.Write([]string{"categories", strconv.Itoa(i + 1), k.Category,
+			strconv.FormatUint(k.Usage(useAllocated), 10), strconv.FormatUint(k.Files, 10), ""})
+	}
+	for i, k := range scan.GroupKinds(res.Kinds, false, useAllocated) {
+		if i >= limit {
+			break
+		}
+		w.Write([]string{"extensions", strconv.Itoa(i + 1), k.Ext + ":" + k.Category,
+			strconv.FormatUint(k.Usage(useAllocated), 10), strconv.FormatUint(k.Files, 10), ""})
+	}
+}
+
+// csvAges keys each bucket as age or user:age and top-dir:age
+func csvAges(w recordWriter, res *scan.Result, limit int) {
+	row := func(report, key string, rank int, h scan.AgeHistogram) {
+		for i, b := range h {
+			w.Write([]string{report, strconv.Itoa(rank), key + scan.AgeBucketNames[i],
+				strconv.FormatUint(b.Usage(useAllocated), 10), strconv.FormatUint(b.Files, 10), ""})
+		}
+	}
+	row("ages", "", 1, res.Ages)
+	for i, uid := range byAgeUsage(res.AgesByUser) {
+		if i >= limit {
+			break
+		}
+		row("ages_by_user", idNames.User(uid)+":", i+1, res.AgesByUser[uid])
+	}
+	for i, top := range byAgeUsage(res.AgesByTopDir) {
+		if i >= limit {
+			break
+		}
+		row("ages_by_top_dir", top+":", i+1, res.AgesByTopDir[top])
+	}
+}
+
+// csvReports writes the -R top-N reports as one table - files and dirs are
+// only filled in for the per user and per group rows
+func csvReports(w recordWriter, res *scan.Result, reports string, limit int) {
+	w.Write([]string{"report", "rank", "key", "value", "files", "dirs"})
+	for _, x := range reports {
+		switch x {
+		case 'l':
+			csvSummary(w, res.LargestFiles, "largest_files")
+		case 'i':
+			csvSummary(w, res.DirsByImmSize, "dirs_by_imm_size")
+		case 'f':
+			csvSummary(w, res.DirsByImmFiles, "dirs_by_imm_files")
+		case 'd':
+			csvSummary(w, res.DirsByRecSize, "dirs_by_rec_size")
+		case 'r':
+			csvSummary(w, res.DirsByImmDirs, "dirs_by_imm_dirs")
+		case 'u':
+			csvUsers(w, res, limit)
+		case 'g':
+			csvGroups(w, res, limit)
+		case 'p':
+			csvUserTops(w, res)
+		case 's':
+			csvSummary(w, res.SparseFiles, "sparse_files")
+		case 'h':
+			csvSummary(w, res.DirsByRecShared, "dirs_by_rec_shared")
+		case 'e':
+			csvKinds(w, res, limit)
+		case 'a':
+			csvAges(w, res, limit)
+		case 'o':
+			csvSummary(w, res.StaleDirs, "stale_dirs")
+		}
+	}
+	if res.Duplicates != nil {
+		csvDups(w, res.Duplicates, limit)
+	}
+}
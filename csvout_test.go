@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// paths that need quoting, or would break an unquoted format
+var csvOddDirs = []string{"/r/a,b", "/r/say \"hi\"", "/r/two\nlines", "/r/tab\there"}
+
+func csvTestResult(t *testing.T) *scan.Result {
+	t.Helper()
+	fsys := scan.NewMemFS()
+	for i, dir := range csvOddDirs {
+		fsys.Add(dir+"/f", scan.MemFile{Size: int64(100 * (i + 1))})
+	}
+	return scanMemFS(t, fsys, scan.Options{})
+}
+
+// csvRows reads back what one delimiter wrote
+func csvRows(t *testing.T, delim string, data []byte, ncols int) [][]string {
+	t.Helper()
+	switch delim {
+	case "comma", "tab":
+		r := csv.NewReader(bytes.NewReader(data))
+		if delim == "tab" {
+			r.Comma = '\t'
+		}
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	fields := strings.Split(string(data), "\x00")
+	if last := fields[len(fields)-1]; last != "" {
+		t.Fatalf("nul output does not end in a NUL: %q", last)
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields)%ncols != 0 {
+		t.Fatalf("%d fields is not a multiple of %d columns", len(fields), ncols)
+	}
+	var rows [][]string
+	for len(fields) > 0 {
+		rows = append(rows, fields[:ncols])
+		fields = fields[ncols:]
+	}
+	return rows
+}
+
+// every delimiter gives back the exact paths and sizes
+func TestCsvTreeDelimiters(t *testing.T) {
+	res := csvTestResult(t)
+	cols, err := selectCsvColumns("path,rec_size,depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delim := range []string{"comma", "tab", "nul"} {
+		t.Run(delim, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRecordWriter(&buf, delim)
+			if err != nil {
+				t.Fatal(err)
+			}
+			treeWalkDetails(w, cols, res.Root, 0, &res.Start, true)
+			w.Flush()
+			if err := w.Error(); err != nil {
+				t.Fatal(err)
+			}
+			rows := csvRows(t, delim, buf.Bytes(), len(cols))
+			if len(rows) != len(csvOddDirs)+2 {
+				t.Fatalf("rows %q", rows)
+			}
+			if !slices.Equal(rows[0], []string{"path", "rec_size", "depth"}) {
+				t.Errorf("header %q", rows[0])
+			}
+			if !slices.Equal(rows[1], []string{"/r", "1000", "0"}) {
+				t.Errorf("root %q", rows[1])
+			}
+			got := map[string]string{}
+			for _, row := range rows[2:] {
+				if row[2] != "1" {
+					t.Errorf("depth of %q", row)
+				}
+				got[row[0]] = row[1]
+			}
+			for i, dir := range csvOddDirs {
+				if want := []string{"100", "200", "300", "400"}[i]; got[dir] != want {
+					t.Errorf("%q size %q want %q", dir, got[dir], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCsvHeader(t *testing.T) {
+	cols, err := selectCsvColumns(defaultCsvColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Split(defaultCsvColumns, ",")
+	if got := csvHeader(cols, false); !slices.Equal(got, want) {
+		t.Errorf("header %q", got)
+	}
+	got := csvHeader(cols, true)
+	if got[10] != "rec_newest_days" || got[7] != "imm_oldest_days" || got[4] != "rec_size" {
+		t.Errorf("flat units header %q", got)
+	}
+}
+
+func TestSelectCsvColumns(t *testing.T) {
+	cols, err := selectCsvColumns("rec_shared, path,imm_allocated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := csvHeader(cols, false); !slices.Equal(got, []string{"rec_shared", "path", "imm_allocated"}) {
+		t.Errorf("columns %q", got)
+	}
+	if _, err := selectCsvColumns("path,size"); err == nil {
+		t.Error("unknown column accepted")
+	}
+	if _, err := newRecordWriter(&bytes.Buffer{}, "pipe"); err == nil {
+		t.Error("unknown delimiter accepted")
+	}
+}
+
+// user and group rows are keyed by name, or the id with -numeric-ids
+func TestCsvOwnerNames(t *testing.T) {
+	defer func(n *scan.Names) { idNames = n }(idNames)
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/a", scan.MemFile{Size: 30, Uid: 1001, Gid: 200})
+	fsys.Add("/r/b", scan.MemFile{Size: 20, Uid: 1002, Gid: 300})
+	res := scanMemFS(t, fsys, scan.Options{TopN: 5, UserTops: true})
+	keys := func() []string {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		csvReports(w, res, "ugp", 10)
+		w.Flush()
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, row := range rows[1:] {
+			keys = append(keys, row[0]+" "+row[2])
+		}
+		return keys
+	}
+
+	idNames = scan.NewNames()
+	if err := idNames.LoadPasswd(strings.NewReader("alice:x:1001:200::/:/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idNames.LoadGroup(strings.NewReader("staff:x:200:\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"users alice", "users 1002", "groups staff", "groups 300",
+		"user_largest_files alice:/r/a", "user_dirs_by_owned_size alice:/r", "user_largest_files 1002:/r/b", "user_dirs_by_owned_size 1002:/r"}
+	if got := keys(); !slices.Equal(got, want) {
+		t.Errorf("keys %q\nwant %q", got, want)
+	}
+	idNames = nil
+	if got := keys(); got[0] != "users 1001" || got[2] != "groups 200" || got[4] != "user_largest_files 1001:/r/a" {
+		t.Errorf("numeric keys %q", got)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+
+	"github.com/google/btree"
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+type snapDiff struct {
+	limit      int
+	growSize   *btree.BTreeG[scan.PathSize]
+	shrinkSize *btree.BTreeG[scan.PathSize]
+	growFiles  *btree.BTreeG[scan.PathSize]
+	shrinkFile *btree.BTreeG[scan.PathSize]
+	growDirs   *btree.BTreeG[scan.PathSize]
+	shrinkDirs *btree.BTreeG[scan.PathSize]
+	newTrees   *btree.BTreeG[scan.PathSize]
+	goneTrees  *btree.BTreeG[scan.PathSize]
+	newCount   uint64
+	goneCount  uint64
+}
+
+func newSnapDiff(limit int) *snapDiff {
+	mk := func() *btree.BTreeG[scan.PathSize] { return btree.NewG[scan.PathSize](16, scan.PathSizeLess) }
+	return &snapDiff{
+		limit:      limit,
+		growSize:   mk(),
+		shrinkSize: mk(),
+		growFiles:  mk(),
+		shrinkFile: mk(),
+		growDirs:   mk(),
+		shrinkDirs: mk(),
+		newTrees:   mk(),
+		goneTrees:  mk(),
+	}
+}
+
+func (d *snapDiff) addDelta(grow, shrink *btree.BTreeG[scan.PathSize], before, after uint64, name string) {
+	if after > before {
+		scan.TrySetNewMaxPath(grow, int64(after-before), name, d.limit)
+	} else if before > after {
+		scan.TrySetNewMaxPath(shrink, int64(before-after), name, d.limit)
+	}
+}
+
+func (d *snapDiff) matched(before, after *snapDir) {
+	d.addDelta(d.growSize, d.shrinkSize, before.dir.RecSize, after.dir.RecSize, after.dir.Name)
+	d.addDelta(d.growFiles, d.shrinkFile, before.dir.RecFiles, after.dir.RecFiles, after.dir.Name)
+	d.addDelta(d.growDirs, d.shrinkDirs, before.dir.RecDirs, after.dir.RecDirs, after.dir.Name)
+}
+
+// diffSnapshots merges the two preorder streams so only the current record of
+// each and the top-N trees are in memory
+func diffSnapshots(before, after *snapReader, d *snapDiff) (*snapOwners, *snapOwners, error) {
+	beforeOwners := newSnapOwners()
+	afterOwners := newSnapOwners()
+	a, err := before.next(beforeOwners)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := after.next(afterOwners)
+	if err != nil {
+		return nil, nil, err
+	}
+	// only the top of a new or gone subtree is reported
+	goneTop, newTop := "", ""
+	for a != nil || b != nil {
+		c := 0
+		switch {
+		case a == nil:
+			c = 1
+		case b == nil:
+			c = -1
+		default:
+			c = cmpSnapPath(a.rel, b.rel)
+		}
+		if c < 0 {
+			if goneTop == "" || !scan.Within(a.rel, goneTop) {
+				goneTop = a.rel
+				d.goneCount++
+				scan.TrySetNewMaxPath(d.goneTrees, int64(a.dir.RecSize), a.dir.Name, d.limit)
+			}
+			a, err = before.next(beforeOwners)
+		} else if c > 0 {
+			if newTop == "" || !scan.Within(b.rel, newTop) {
+				newTop = b.rel
+				d.newCount++
+				scan.TrySetNewMaxPath(d.newTrees, int64(b.dir.RecSize), b.dir.Name, d.limit)
+			}
+			b, err = after.next(afterOwners)
+		} else {
+			d.matched(a, b)
+			a, err = before.next(beforeOwners)
+			if err == nil {
+				b, err = after.next(afterOwners)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return beforeOwners, afterOwners, nil
+}
+
+func signedBytes(d int64) string {
+	if d < 0 {
+		return "-" + statticker.FormatBytes(-d)
+	}
+	return "+" + statticker.FormatBytes(d)
+}
+
+func signedCount(d int64) string {
+	if d < 0 {
+		return "-" + statticker.AddCommas(-d)
+	}
+	return "+" + statticker.AddCommas(d)
+}
+
+// idDelta is the change of one user or group id
+type idDelta struct {
+	id    uint32
+	size  int64
+	files int64
+	dirs  int64
+}
+
+// idDeltas lists every id of either side, the biggest change in size first
+func idDeltas[T any](before, after map[uint32]T, stats func(T) (size, files, dirs uint64)) []idDelta {
+	var list []idDelta
+	for id, a := range after {
+		as, af, ad := stats(a)
+		bs, bf, bd := stats(before[id])
+		list = append(list, idDelta{id, diffu64(as, bs), diffu64(af, bf), diffu64(ad, bd)})
+	}
+	for id, b := range before {
+		if _, ok := after[id]; !ok {
+			bs, bf, bd := stats(b)
+			list = append(list, idDelta{id, -int64(bs), -int64(bf), -int64(bd)})
+		}
+	}
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	slices.SortFunc(list, func(i, j idDelta) int {
+		if abs(i.size) != abs(j.size) {
+			return cmpBool(abs(i.size) < abs(j.size), abs(i.size) > abs(j.size))
+		}
+		return int(i.id) - int(j.id)
+	})
+	return list
+}
+
+func printIdDiff(title, nameHeader, idHeader string, name func(uint32) string, list []idDelta, limit int) {
+	fmt.Println(title)
+	fmt.Printf("%-12s %6s  %10s %10s %10s\n", nameHeader, idHeader, "Space", "Files", "Dirs")
+	for i, u := range list {
+		if i >= limit {
+			break
+		}
+		fmt.Printf("%-12s %6d  %10s %10s %10s\n", name(u.id), u.id, signedBytes(u.size), signedCount(u.files), signedCount(u.dirs))
+	}
+}
+
+func printUserDiff(before, after *snapOwners, limit int) {
+	list := idDeltas(before.users, after.users, func(u scan.UserStats) (uint64, uint64, uint64) { return u.Size, u.Files, u.Dirs })
+	printIdDiff("Change in usage by user id", "User", "UID", idNames.User, list, limit)
+}
+
+func printGroupDiff(before, after *snapOwners, limit int) {
+	list := idDeltas(before.groups, after.groups, func(g scan.GroupStats) (uint64, uint64, uint64) { return g.Size, g.Files, g.Dirs })
+	printIdDiff("Change in usage by group id", "Group", "GID", idNames.Group, list, limit)
+}
+
+// diffMain is the "diff" sub command: du diff [options] before.snap after.snap
+func diffMain(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	limit := flags.Int("l", 10, "limit reports to the top N")
+	flatUnits := flags.Bool("F", false, "use basic units for size")
+	passwdFile := flags.String("passwd", "", "resolve user ids with this passwd format file instead of the local users")
+	groupFile := flags.String("group", "", "resolve group ids with this group format file instead of the local groups")
+	numericIds := flags.Bool("numeric-ids", false, "show user and group ids as numbers without looking up names")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s diff [OPTIONS] BEFORE_SNAPSHOT AFTER_SNAPSHOT\n", path.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	if !*numericIds {
+		var err error
+		if idNames, err = loadNames(*passwdFile, *groupFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading id names:", err)
+			return 1
+		}
+	}
+
+	before, err := openSnapshot(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening snapshot:", err)
+		return 3
+	}
+	defer before.Close()
+	after, err := openSnapshot(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening snapshot:", err)
+		return 3
+	}
+	defer after.Close()
+
+	for _, s := range []*snapReader{before, after} {
+		incomplete := ""
+		if s.header.flags&snapFlagIncomplete != 0 {
+			incomplete = " INCOMPLETE"
+		}
+		fmt.Printf("%s: %s at %s - %s in %s files and %s directories%s\n", s.f.Name(), s.header.root,
+			s.header.start.Format("2006-01-02 15:04:05"), statticker.FormatBytes(s.header.size),
+			statticker.AddCommas(s.header.files), statticker.AddCommas(s.header.dirs), incomplete)
+	}
+	fmt.Printf("Total change: %s, %s files, %s directories\n",
+		signedBytes(diffu64(after.header.size, before.header.size)),
+		signedCount(diffu64(after.header.files, before.header.files)),
+		signedCount(diffu64(after.header.dirs, before.header.dirs)))
+
+	d := newSnapDiff(*limit)
+	beforeOwners, afterOwners, err := diffSnapshots(before, after, d)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading snapshot:", err)
+		return 3
+	}
+
+	fmt.Println()
+	printSummary(scan.Descending(d.growSize), true, "directories by growth in total file size recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.shrinkSize), true, "directories by shrinkage in total file size recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.growFiles), false, "directories by growth in file count recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.shrinkFile), false, "directories by shrinkage in file count recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.growDirs), false, "directories by growth in directory count recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.shrinkDirs), false, "directories by shrinkage in directory count recursively", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.newTrees), true, "new subtrees ("+strconv.FormatUint(d.newCount, 10)+" total) by size", *flatUnits)
+	fmt.Println()
+	printSummary(scan.Descending(d.goneTrees), true, "gone subtrees ("+strconv.FormatUint(d.goneCount, 10)+" total) by size", *flatUnits)
+	fmt.Println()
+	printUserDiff(beforeOwners, afterOwners, *limit)
+	fmt.Println()
+	printGroupDiff(beforeOwners, afterOwners, *limit)
+	return 0
+}
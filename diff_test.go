@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func diffPaths(list []scan.PathSize) map[string]int64 {
+	m := map[string]int64{}
+	for _, p := range list {
+		m[p.Path] = p.Size
+	}
+	return m
+}
+
+// a/b and a-b only line up when both streams sort the separator first
+func TestDiffSnapshots(t *testing.T) {
+	before := scan.NewMemFS()
+	before.Add("/r/a/b/f", scan.MemFile{Size: 100, Uid: 1, Gid: 10})
+	before.Add("/r/a-b/g", scan.MemFile{Size: 50, Uid: 1, Gid: 10})
+	before.Add("/r/old/x", scan.MemFile{Size: 30, Uid: 2, Gid: 20})
+	before.Add("/r/old/sub/y", scan.MemFile{Size: 20, Uid: 2, Gid: 20})
+
+	after := scan.NewMemFS()
+	after.Add("/r/a/b/f", scan.MemFile{Size: 150, Uid: 1, Gid: 10})
+	after.Add("/r/a/b/new/h", scan.MemFile{Size: 40, Uid: 3, Gid: 30})
+	after.Add("/r/a-b/g", scan.MemFile{Size: 50, Uid: 1, Gid: 10})
+	after.Add("/r/fresh/deep/i", scan.MemFile{Size: 8, Uid: 3, Gid: 30})
+
+	d := newSnapDiff(10)
+	beforeOwners, afterOwners, err := diffSnapshots(writeSnap(t, scanMemFS(t, before, scan.Options{})), writeSnap(t, scanMemFS(t, after, scan.Options{})), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the top of each new or gone subtree
+	if got := diffPaths(scan.Descending(d.newTrees)); d.newCount != 2 || len(got) != 2 || got["/r/a/b/new"] != 40 || got["/r/fresh"] != 8 {
+		t.Errorf("new %d %v", d.newCount, got)
+	}
+	if got := diffPaths(scan.Descending(d.goneTrees)); d.goneCount != 1 || len(got) != 1 || got["/r/old"] != 50 {
+		t.Errorf("gone %d %v", d.goneCount, got)
+	}
+	if got := diffPaths(scan.Descending(d.growSize)); len(got) != 3 || got["/r/a/b"] != 90 || got["/r/a"] != 90 || got["/r"] != 48 {
+		t.Errorf("grown %v", got)
+	}
+	if got := diffPaths(scan.Descending(d.shrinkSize)); len(got) != 0 {
+		t.Errorf("shrunk %v", got)
+	}
+
+	users := idDeltas(beforeOwners.users, afterOwners.users, func(u scan.UserStats) (uint64, uint64, uint64) { return u.Size, u.Files, u.Dirs })
+	// MemFS makes the directories between files as uid and gid 0
+	want := []idDelta{{1, 50, 0, 0}, {2, -50, -2, 0}, {3, 48, 2, 0}, {0, 0, 0, 1}}
+	if !slices.Equal(users, want) {
+		t.Errorf("users %+v", users)
+	}
+	groups := idDeltas(beforeOwners.groups, afterOwners.groups, func(g scan.GroupStats) (uint64, uint64, uint64) { return g.Size, g.Files, g.Dirs })
+	want = []idDelta{{10, 50, 0, 0}, {20, -50, -2, 0}, {30, 48, 2, 0}, {0, 0, 0, 1}}
+	if !slices.Equal(groups, want) {
+		t.Errorf("groups %+v", groups)
+	}
+}
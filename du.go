@@ -4,317 +4,72 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io/fs"
-	"math"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/google/btree"
+	"github.com/sflanaga/du2go/scan"
 	"github.com/sflanaga/statticker"
-	"golang.org/x/sync/semaphore"
 )
 
 var isWindows = runtime.GOOS == "windows"
 
-var totalSize = statticker.NewStat("bytes", statticker.Bytes)
-var countFiles = statticker.NewStat("files", statticker.Count)
-var countDirs = statticker.NewStat("dir", statticker.Count)
-var goroutines = statticker.NewStat("goroutines", statticker.Gauge)
-
-type DirInfo struct {
-	name         string
-	imm_size     uint64
-	imm_files    uint64
-	imm_dirs     uint64
-	imm_old_file int64
-	imm_new_file int64
-	rec_size     uint64
-	rec_files    uint64
-	rec_dirs     uint64
-	rec_old_file int64
-	rec_new_file int64
-	children     []*DirInfo
-}
-
-func NewDirInfo(name string) *DirInfo {
-	return &DirInfo{
-		name:         name,
-		imm_size:     0,
-		imm_files:    0,
-		imm_dirs:     0,
-		imm_old_file: math.MaxInt64,
-		imm_new_file: math.MinInt64,
-		rec_size:     0,
-		rec_files:    0,
-		rec_dirs:     0,
-		rec_old_file: math.MaxInt64,
-		rec_new_file: math.MinInt64,
-		children:     make([]*DirInfo, 0),
-	}
-}
-
-type PathSize struct {
-	size int64
-	path string
-}
-
-func pathSizeLess(a, b PathSize) bool {
-	return a.size < b.size
-}
-
-type maxGlobalFile struct {
-	limits  int
-	mtx     sync.Mutex
-	minFile int64
-	mapMax  *btree.BTreeG[PathSize]
-}
-
-func NewMaxGlobalFile(limit int) *maxGlobalFile {
-	return &maxGlobalFile{
-		limits:  limit,
-		mtx:     sync.Mutex{},
-		minFile: 0,
-		mapMax:  btree.NewG[PathSize](16, pathSizeLess),
-	}
-}
+// when set, reports rank and show allocated disk bytes (st_blocks*512)
+// instead of the apparent size of files
+var useAllocated = false
 
-var maxFiles *maxGlobalFile = nil
+// idNames resolves user and group ids in reports - nil with -numeric-ids
+var idNames *scan.Names
 
-func (m *maxGlobalFile) setMaxFile(size int64, path *string) {
-	// we do the quick check to avoid the mutex lock
-	currMin := atomic.LoadInt64(&m.minFile)
-	if size > currMin {
-		m.mtx.Lock()
-		defer m.mtx.Unlock()
-
-		currMin := atomic.LoadInt64(&m.minFile)
-		if size > currMin {
-			m.mapMax.ReplaceOrInsert(PathSize{size: size, path: *path})
-			if m.mapMax.Len() > m.limits {
-				m.mapMax.DeleteMin()
-			}
-			if currMin > size {
-				atomic.StoreInt64(&m.minFile, size)
-			}
-		}
-	}
-}
-
-var fsFilter = map[string]bool{
-	"/proc": true,
-	"/dev":  true,
-	"/sys":  true,
-}
-
-// func getUserIDFromFileInfo(fileInfo os.FileInfo) (uint32, error) {
-//     var uid uint32
-//     switch runtime.GOOS {
-//     case "windows":
-//         // On Windows, use the FileBasicInfo structure
-//         fileBasicInfo, err := syscall.GetFileInformationByHandle(fileInfo.Sys().(*syscall.Handle))
-//         if err != nil {
-//             return 0, fmt.Errorf("failed to get file information: %w", err)
-//         }
-//         uid = fileBasicInfo.Owner.LowPart
-//     default:
-//         // On Unix-like systems, use the Stat_t structure
-//         uid = fileInfo.Sys().(*syscall.Stat_t).Uid
-//     }
-//     return uid, nil
-// }
-
-func walkGo(debug bool, dir *DirInfo, limitworkers *semaphore.Weighted, goroutine bool, depth int) {
-	if goroutine {
-		// we need to release the allocated thread/goroutine if we stop early
-		// we only need to do this when we did NOT steal the next directory/task
-		// also note that defer DOES work conditionally here because it works at
-		// the end of the current function and NOT the current scope
-		goroutines.Add(1)
-		defer goroutines.Add(-1)
-		defer limitworkers.Release(1)
-	}
-
-	user := UserStats{NULL_USER_ID, 0, 0, 0}
-	defer func() {
-		loadUserInfo(user)
-		// println("loading user info")
-	}()
-
-	// goofy special filters
-	if depth <= 1 {
-		if _, ok := fsFilter[dir.name]; ok {
-			atomic.AddUint64(&filterDirs, 1)
-			if debug {
-				fmt.Fprintf(os.Stderr, "skipping path %s as special\n", dir.name)
-			}
-			return
-		}
-	}
-
-	files, err := os.ReadDir(dir.name)
-	if err != nil {
-		atomic.AddUint64(&dirListErrors, 1)
-		if debug {
-			fmt.Fprintln(os.Stderr, "Error reading directory:", err)
-		}
-		return
-	}
-	newest := int64(math.MinInt64)
-	oldest := int64(math.MaxInt64)
-
-	for _, file := range files {
-		var cleanPath = filepath.Join(dir.name, file.Name())
-		if file.IsDir() {
-			subdir := NewDirInfo(cleanPath)
-			dir.children = append(dir.children, subdir)
-			// atomic.AddUint64(&countDirs, 1)
-			countDirs.Add(1)
-			dir.imm_dirs++
-			dir.rec_dirs++
-			// fmt.Println(cleanPath, file.IsDir())
-			// cheesey simple work-stealing
-
-			stats, err_st := file.Info()
-			if err_st == nil {
-				uid := getUserId(&stats)
-				user.addDir(uid)
-			} else {
-				println("error on ", cleanPath, " of ", err_st)
-			}
-
-			if limitworkers.TryAcquire(1) {
-				go walkGo(debug, subdir, limitworkers, true, depth+1)
+func printSummary(list []scan.PathSize, bytes bool, title string, flatUnits bool) {
+	fmt.Println(title)
+	for _, value := range list {
+		if bytes {
+			if flatUnits {
+				fmt.Printf("%12d %s\n", uint64(value.Size), value.Path)
 			} else {
-				walkGo(debug, subdir, limitworkers, false, depth+1)
+				fmt.Printf("%8s %s\n", statticker.FormatBytes(uint64(value.Size)), value.Path)
 			}
-		} else if file.Type().IsRegular() || (fs.ModeIrregular&file.Type() != 0) {
-			stats, err_st := file.Info()
-			if err_st != nil {
-				atomic.AddUint64(&filestatErrors, 1)
-				if debug {
-					fmt.Fprintln(os.Stderr, "... Error reading file info:", err_st)
-				}
-				continue
-			}
-			sz := stats.Size()
-			// uid := getUserId(&stats)
-			if stats.ModTime().Unix() > newest {
-				newest = stats.ModTime().Unix()
-			}
-			if stats.ModTime().Unix() < oldest {
-				oldest = stats.ModTime().Unix()
-			}
-			countFiles.Add(1)
-			totalSize.Add(int64(sz))
-			// atomic.AddUint64(&countFiles, 1)
-			// atomic.AddUint64(&totalSize, uint64(sz))
-
-			dir.imm_size += uint64(sz)
-			dir.rec_size += uint64(sz)
-
-			dir.imm_files++
-			dir.rec_files++
-
-			dir.imm_new_file = newest
-			dir.imm_old_file = oldest
-
-			dir.rec_new_file = maxInt64(dir.rec_new_file, newest)
-			dir.rec_old_file = minInt64(dir.rec_old_file, oldest)
-
-			maxFiles.setMaxFile(sz, &cleanPath)
-
-			uid := getUserId(&stats)
-			user.addFile(uid, uint64(sz))
 		} else {
-			atomic.AddUint64(&notDirOrFile, 1)
-			countFileTypes.Compute(file.Type(), func(oldValue int, loaded bool) (newValue int, delete bool) {
-				newValue = oldValue + 1
-				return
-			})
-
-			// _, _ = countFileTypes.LoadOrStore(key, func(value interface{}) interface{} {
-			// 	if value == nil {
-			// 		return 1
-			// 	}
-			// 	return value.(V) + 1
-			// })
-			if debug {
-				fmt.Fprintln(os.Stderr, "... skipping file:", cleanPath, " type: ", modeToStringLong(file.Type()))
-			}
+			fmt.Printf("%8d %s\n", value.Size, value.Path)
 		}
 	}
-	// loadUserInfo(user)
-
 }
 
-func treePerk(dir *DirInfo, depth int) {
-	// fmt.Printf("%9s %s %s %d\n", formatBytes(dir.imm_size), tabs[0:depth], dir.name, depth)
-	for _, child := range dir.children {
-		treePerk(child, depth+1)
-
-		dir.rec_size += child.rec_size
-		dir.rec_files += child.rec_files
-		dir.rec_dirs += child.rec_dirs
-		dir.rec_new_file = maxInt64(dir.rec_new_file, child.rec_new_file)
-		dir.rec_old_file = minInt64(dir.rec_old_file, child.rec_old_file)
+func reportAnyScanErrors(res *scan.Result) {
+	errs := &res.Errors
+	if errs.FileStat > 0 {
+		fmt.Printf("%8d file stat errors\n", errs.FileStat)
 	}
-}
-
-// var maxDirByImmSize = btree.NewMap[int64, string](16)
-func trySetNewMaxPath(tree *btree.BTreeG[PathSize], size int64, path *string, limit int) {
-	tree.ReplaceOrInsert(PathSize{size, *path})
-	if tree.Len() > limit {
-		tree.DeleteMin()
+	printFilteredStringTypes(errs.ByFileType)
+	if errs.FilteredDirs > 0 {
+		fmt.Printf("%8d special directories filtered\n", errs.FilteredDirs)
 	}
-}
-
-var maxDirByImmSize = btree.NewG[PathSize](16, pathSizeLess)
-var maxDirByImmCount = btree.NewG[PathSize](16, pathSizeLess)
-var maxDirByImmDirCount = btree.NewG[PathSize](16, pathSizeLess)
-var maxDirByRecSize = btree.NewG[PathSize](16, pathSizeLess)
-
-func walkTreeSummary(dir *DirInfo, sumLimit int, depth int) {
-	trySetNewMaxPath(maxDirByImmSize, int64(dir.imm_size), &dir.name, sumLimit)
-	trySetNewMaxPath(maxDirByImmCount, int64(dir.imm_files), &dir.name, sumLimit)
-	trySetNewMaxPath(maxDirByImmDirCount, int64(dir.imm_dirs), &dir.name, sumLimit)
-	trySetNewMaxPath(maxDirByRecSize, int64(dir.rec_size), &dir.name, sumLimit)
-	for _, child := range dir.children {
-		walkTreeSummary(child, sumLimit, depth+1)
+	if errs.DirList > 0 {
+		fmt.Printf("%8d directories that cannot be listed\n", errs.DirList)
 	}
-}
-
-func printSummary(tree *btree.BTreeG[PathSize], bytes bool, title string, flatUnits bool) {
-	fmt.Println(title)
-	tree.Descend(func(value PathSize) bool {
-		if bytes {
-			if flatUnits {
-				fmt.Printf("%12d %s\n", uint64(value.size), value.path)
-			} else {
-				fmt.Printf("%8s %s\n", statticker.FormatBytes(uint64(value.size)), value.path)
-			}
-		} else {
-			fmt.Printf("%8d %s\n", value.size, value.path)
+	if errs.Archives > 0 {
+		fmt.Printf("%8d archives that cannot be read counted as plain files\n", errs.Archives)
+	}
+	if len(errs.SkippedMounts) > 0 {
+		fmt.Printf("%8d mount points skipped as on another filesystem:\n", len(errs.SkippedMounts))
+		for _, p := range errs.SkippedMounts {
+			fmt.Printf("         %s\n", p)
 		}
-		return true
-	})
-}
-
-func reportAnyScanErrors() {
-	if filestatErrors > 0 {
-		fmt.Printf("%8d file stat errors\n", filestatErrors)
 	}
-	printFilteredStringTypes(countFileTypes)
-	if filterDirs > 0 {
-		fmt.Printf("%8d special directories filtered\n", filterDirs)
+	for _, p := range errs.ExcludedMounts {
+		fmt.Printf("         excluded mount %s\n", p)
 	}
-	if dirListErrors > 0 {
-		fmt.Printf("%8d directories that cannot be listed\n", dirListErrors)
+	if res.Totals.ExcludedFiles > 0 || res.Totals.ExcludedDirs > 0 {
+		fmt.Printf("%8d files and %d directories excluded by pattern\n", res.Totals.ExcludedFiles, res.Totals.ExcludedDirs)
+	}
+	if res.Totals.HardLinkDups > 0 {
+		fmt.Printf("%8d extra hard links not counted in totals\n", res.Totals.HardLinkDups)
 	}
 }
 
@@ -348,32 +103,184 @@ func duStatPrinter(t *statticker.Ticker, samplePeriod time.Duration, finalOutput
 
 func main() {
 
-	start := time.Now()
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(diffMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(clientMain(os.Args[2:]))
+	}
 
-	rootDir := flag.String("d", ".", "root directory to scan")
+	var opts scan.Options
+	rootDir := flag.String("d", ".", "root directory to scan - a tar, tar.gz, tar.zst or zip file is scanned as a directory")
 	ticker_duration := flag.Duration("i", 1*time.Second, "ticker duration")
 	dumpFullDetails := flag.Bool("D", false, "dump full details")
 	flatUnits := flag.Bool("F", false, "use basic units for size and age - useful for simpler post processing")
-	reports := flag.String("R", "lifdru", "Top stats reports: \n l - largest file\n i - directories by total file size immediately in it\n f - directories by file count immediately in it\n d - directories by directory count immediately in it\n r - directories by total file size recursively in it\n u - total file usage by user id\n")
+	reports := flag.String("R", "lifdru", "Top stats reports: \n l - largest file\n i - directories by total file size immediately in it\n f - directories by file count immediately in it\n d - directories by directory count immediately in it\n r - directories by total file size recursively in it\n u - total file usage by user id\n g - total file usage by group id\n p - largest files and directories of each user id (see -users)\n h - directories by hard linked bytes shared with other directories (needs -H)\n s - sparse files with the largest gap between apparent and allocated size\n e - usage by file extension and category, overall and per top-level directory\n a - usage by file age, overall, per user id and per top-level directory (see -time)\n o - stale directories with no file newer than -stale, by total size recursively\n")
 	cpuNum := runtime.NumCPU()
 	threadLimit := flag.Int("t", cpuNum, "limit number of threads")
 	summaryLimit := flag.Int("l", 10, "limit stat reports to the top N")
 	debug := flag.Bool("v", false, "write per file/directory errors during scan")
+	flag.BoolVar(&useAllocated, "A", false, "use allocated disk blocks instead of apparent file size in all reports")
+	flag.BoolVar(&opts.OneFileSystem, "x", false, "stay on the filesystem of the root directory and skip other mounts")
+	excludeFs := flag.String("exclude-fs", scan.DefaultExcludeFsTypes, "comma list of filesystem types whose mounts are not scanned (linux)")
+	includeFs := flag.String("include-fs", "", "comma list of filesystem types - only mounts of these types are scanned (linux)")
+	flag.Var(&opts.Exclude, "exclude", "skip files and directories matching a glob or re:regex - repeatable")
+	flag.Var(&opts.Include, "include", "only count files matching a glob or re:regex - repeatable")
+	format := flag.String("format", "text", "output format: text, csv, json or ndjson (one record per directory streamed as its subtree completes)")
+	csvCols := flag.String("csv-cols", defaultCsvColumns, "columns for -D - also available: imm_allocated,rec_allocated,rec_shared")
+	csvDelim := flag.String("csv-delim", "comma", "csv delimiter: comma, tab or nul (no quoting, every field followed by a NUL)")
+	jsonWithTree := flag.Bool("json-tree", false, "include the full nested directory tree in json output")
+	browse := flag.Bool("browse", false, "open an interactive browser over the scanned tree")
+	var cleanCrit cleanupCriteria
+	cleanOlder := flag.String("clean-older", "", "cleanup files whose mtime is older than this age, e.g. 90d")
+	cleanLarger := flag.String("clean-larger", "", "cleanup files at least this large, e.g. 1G")
+	flag.Var(&cleanCrit.patterns, "clean-match", "cleanup files or whole directories matching a glob or re:regex - repeatable")
+	cleanAction := flag.String("clean-action", cleanDelete, "cleanup action: delete or trash")
+	cleanYes := flag.Bool("clean-yes", false, "actually perform the cleanup - without it only the dry run preview is shown")
+	cleanLog := flag.String("clean-log", "du2go-cleanup.log", "audit log of everything removed by cleanup")
+	serveAddr := flag.String("serve", "", "run as a service on this address, e.g. :8080, serving the latest scan over http")
+	rescanInterval := flag.Duration("rescan", time.Hour, "time between scans in -serve mode")
+	snapshotFile := flag.String("snapshot", "", "save the scanned tree and user stats to this file for a later diff")
+	timeout := flag.Duration("timeout", 0, "stop the scan after this long and report on what was read")
+	excludeFrom := flag.String("exclude-from", "", "file of exclude patterns, one per line")
+	metricsAddr := flag.String("metrics", "", "serve live prometheus metrics of the scan on this address at /metrics, e.g. :9101")
+	textfile := flag.String("textfile", "", "write prometheus metrics for the node_exporter textfile collector to this .prom file when the scan completes")
+	metricsLimit := flag.Int("metrics-limit", 20, "limit the users and directories labelled in -textfile metrics to the top N")
+	categoriesFile := flag.String("categories", "", "file of extension categories for -R e, one per line as \"name: .ext .ext name-glob\" - default video, audio, images, archives, logs, source, build outputs, data, documents and core dumps")
+	flag.BoolVar(&opts.Archives, "archives", false, "descend into tar, tar.gz, tar.zst and zip files found during the scan as if they were directories")
+	timeField := flag.String("time", "mtime", "file time for ages, stale directories and oldest/newest: mtime, atime, ctime or birth (statx on linux)")
+	staleAge := flag.String("stale", "180d", "age with no newer file that makes a directory stale for -R o, e.g. 1y")
+	passwdFile := flag.String("passwd", "", "resolve user ids with this passwd format file instead of the local users - for scanning NFS exports of other hosts")
+	groupFile := flag.String("group", "", "resolve group ids with this group format file instead of the local groups")
+	userList := flag.String("users", "", "comma list of user names or uids that -R p is limited to - default the top users by usage")
+	quotaFile := flag.String("quota", "", "policy file of soft and hard limits checked after the scan, one per line as \"path|user|group target metric soft hard\" - exits 5 when only soft limits are broken and 6 for any hard limit")
+	flag.BoolVar(&opts.Duplicates, "dups", false, "after the scan find files with the same content and report the bytes that removing the copies would free")
+	dupMinSize := flag.String("dup-min-size", "1", "only check files at least this large for -dups, e.g. 1M")
+	numericIds := flag.Bool("numeric-ids", false, "show user and group ids as numbers without looking up names")
+	flag.BoolVar(&opts.DedupHardLinks, "H", false, "count hard linked files only once - deduplicated instead of apparent totals")
+
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [OPTIONS]\n", path.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
 	for _, x := range *reports {
-		if !strings.ContainsRune("lifdru", x) {
+		if !strings.ContainsRune("lifdruhseaogp", x) {
 			fmt.Printf("Unknown -R sub option: '%c'\n", x)
 			os.Exit(1)
 		}
 	}
 
-	var workerSema = semaphore.NewWeighted(int64(*threadLimit))
+	if *format != "text" && *format != "csv" && *format != "json" && *format != "ndjson" {
+		fmt.Fprintf(os.Stderr, "Unknown -format: '%s'\n", *format)
+		os.Exit(1)
+	}
+	if *cleanOlder != "" {
+		var err error
+		if cleanCrit.olderThan, err = parseAge(*cleanOlder); err != nil {
+			fmt.Fprintln(os.Stderr, "Options error - -clean-older:", err)
+			os.Exit(1)
+		}
+	}
+	if *dupMinSize != "" {
+		size, err := parseSize(*dupMinSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Options error - -dup-min-size:", err)
+			os.Exit(1)
+		}
+		opts.DupMinSize = int64(size)
+	}
+	if opts.Duplicates && *format == "ndjson" {
+		fmt.Fprintln(os.Stderr, "Options error - -dups cannot be used with -format ndjson")
+		os.Exit(1)
+	}
+	if *cleanLarger != "" {
+		var err error
+		if cleanCrit.largerThan, err = parseSize(*cleanLarger); err != nil {
+			fmt.Fprintln(os.Stderr, "Options error - -clean-larger:", err)
+			os.Exit(1)
+		}
+	}
+	if *cleanAction != cleanDelete && *cleanAction != cleanTrash {
+		fmt.Fprintf(os.Stderr, "Options error - unknown -clean-action '%s'\n", *cleanAction)
+		os.Exit(1)
+	}
+	if cleanCrit.isSet() && *format == "ndjson" {
+		fmt.Fprintln(os.Stderr, "Options error - cleanup needs the full tree and cannot be used with -format ndjson")
+		os.Exit(1)
+	}
+	if *browse && *format == "ndjson" {
+		fmt.Fprintln(os.Stderr, "Options error - -browse needs the full tree and cannot be used with -format ndjson")
+		os.Exit(1)
+	}
+	if *textfile != "" && *format == "ndjson" {
+		fmt.Fprintln(os.Stderr, "Options error - -textfile needs the full tree and cannot be used with -format ndjson")
+		os.Exit(1)
+	}
+	if *quotaFile != "" && (*format == "ndjson" || *serveAddr != "" || *browse) {
+		fmt.Fprintln(os.Stderr, "Options error - -quota cannot be used with -format ndjson, -serve or -browse")
+		os.Exit(1)
+	}
+	if *snapshotFile != "" && *format == "ndjson" {
+		fmt.Fprintln(os.Stderr, "Options error - -snapshot needs the full tree and cannot be used with -format ndjson")
+		os.Exit(1)
+	}
+	selectedCols, err := selectCsvColumns(*csvCols)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Options error - -csv-cols:", err)
+		os.Exit(1)
+	}
+	csvOut, err := newRecordWriter(os.Stdout, *csvDelim)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Options error - -csv-delim:", err)
+		os.Exit(1)
+	}
 
-	flag.Usage = func() {
-		fmt.Printf("Usage: %s [OPTIONS]\n", path.Base(os.Args[0]))
-		flag.PrintDefaults()
+	if strings.ContainsRune(*reports, 'e') {
+		if opts.Categories, err = loadCategories(*categoriesFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading categories file:", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.TimeField, err = scan.ParseTimeField(*timeField); err != nil {
+		fmt.Fprintln(os.Stderr, "Options error - -time:", err)
+		os.Exit(1)
+	}
+	if *cleanOlder != "" && opts.TimeField != scan.TimeMtime {
+		fmt.Fprintln(os.Stderr, "Options error - -clean-older works on mtime and cannot be used with -time", opts.TimeField)
+		os.Exit(1)
+	}
+	if strings.ContainsRune(*reports, 'o') {
+		if opts.StaleAge, err = parseAge(*staleAge); err != nil {
+			fmt.Fprintln(os.Stderr, "Options error - -stale:", err)
+			os.Exit(1)
+		}
+	}
+	opts.AgeHistogram = strings.ContainsRune(*reports, 'a')
+
+	if !*numericIds {
+		if idNames, err = loadNames(*passwdFile, *groupFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading id names:", err)
+			os.Exit(1)
+		}
+	}
+
+	opts.UserTops = strings.ContainsRune(*reports, 'p')
+	if *userList != "" {
+		if opts.UserTopsFor, err = parseUserList(*userList); err != nil {
+			fmt.Fprintln(os.Stderr, "Options error - -users:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *excludeFrom != "" {
+		if err := opts.Exclude.LoadFile(*excludeFrom); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading exclude file:", err)
+			os.Exit(1)
+		}
 	}
-	flag.Parse()
 
 	if flag.NArg() > 0 {
 		fmt.Fprintln(os.Stderr, "Options error - Extra/orphaned arguments - most likely not using -d option")
@@ -383,17 +290,64 @@ func main() {
 		os.Exit(2)
 	}
 
-	maxFiles = NewMaxGlobalFile(*summaryLimit)
 	absPath, err := filepath.Abs(*rootDir)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error getting absolute path:", err)
 		os.Exit(3)
 	}
 
+	var quotaRules []quotaRule
+	if *quotaFile != "" {
+		if quotaRules, err = loadQuotaPolicy(*quotaFile, absPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading quota policy:", err)
+			os.Exit(1)
+		}
+	}
+
+	opts.Threads = *threadLimit
+	opts.TopN = *summaryLimit
+	opts.Allocated = useAllocated
+	if *debug {
+		opts.Debug = os.Stderr
+	}
+	if mounts, err := scan.LoadMounts(); err == nil {
+		opts.Mounts = mounts
+		opts.ExcludeFsTypes = parseFsTypeList(*excludeFs)
+		opts.IncludeFsTypes = parseFsTypeList(*includeFs)
+	} else if *debug {
+		fmt.Fprintln(os.Stderr, "mount table not available, using static filter:", err)
+	}
+
+	if *serveAddr != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Fprintf(os.Stderr, "serving scans of %s on %s every %v\n", absPath, *serveAddr, *rescanInterval)
+		srv := newScanServer(absPath, opts, *rescanInterval)
+		if err := srv.serve(ctx, *serveAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "Error serving:", err)
+			os.Exit(4)
+		}
+		return
+	}
+
+	if *format == "ndjson" {
+		startStream(os.Stdout)
+		opts.Stream = writeStreamRecord
+	}
+	var cleanFiles *cleanupFiles
+	if cleanCrit.isSet() {
+		cleanFiles = newCleanupFiles(&cleanCrit, time.Now())
+		opts.Hooks.OnFile = cleanFiles.onFile
+	}
+	scanner := scan.New(opts)
+
 	var statList []*statticker.Stat
-	statList = append(statList, countFiles)
-	statList = append(statList, countDirs)
-	statList = append(statList, totalSize)
+	statList = append(statList, scanner.Progress.Files)
+	statList = append(statList, scanner.Progress.Dirs)
+	statList = append(statList, scanner.Progress.Size)
+	if useAllocated {
+		statList = append(statList, scanner.Progress.Allocated)
+	}
 
 	var ticker *statticker.Ticker
 	if ticker_duration.Seconds() != 0 {
@@ -402,70 +356,239 @@ func main() {
 		ticker.Start()
 	}
 
-	root := NewDirInfo(absPath)
-	var ctx = context.Background()
+	if *metricsAddr != "" {
+		startMetricsServer(*metricsAddr, absPath, scanner)
+	}
 
-	workerSema.Acquire(ctx, 1)
-	walkGo(*debug, root, workerSema, true, 0)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	scanDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// a second Ctrl-C falls through to the default and kills us
+			stop()
+			fmt.Fprintln(os.Stderr, "scan stopping:", ctx.Err(), "- waiting for in-flight directories")
+		case <-scanDone:
+		}
+	}()
 
-	workerSema.Acquire(ctx, int64(*threadLimit))
+	res, err := scanner.Scan(ctx, absPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting root directory info:", err)
+		os.Exit(3)
+	}
+	close(scanDone)
+	incomplete := res.Incomplete
 
-	elapse := time.Since(start)
+	elapse := res.Elapsed
 	if ticker != nil {
 		ticker.Stop()
 	}
 
-	treePerk(root, 0)
+	if opts.Stream != nil {
+		// everything was already written as the walk unwound
+		if err := flushStream(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing records:", err)
+			os.Exit(4)
+		}
+		if incomplete != nil {
+			fmt.Fprintf(os.Stderr, "INCOMPLETE SCAN: %v - %d directories not finished\n", incomplete, len(res.Unfinished))
+		}
+		fmt.Fprintln(os.Stderr, "Total size:", statticker.FormatBytes(res.Totals.Size), "in",
+			statticker.AddCommas(res.Totals.Files), "files and", res.Totals.Dirs, "directories", "done in", elapse)
+		return
+	}
 
-	fmt.Printf("Scanned directory path: %s\n", *rootDir)
+	if cleanFiles != nil {
+		if err := runCleanup(ctx, res, cleanFiles, *cleanAction, *cleanYes, *cleanLog); err != nil {
+			fmt.Fprintln(os.Stderr, "Error during cleanup:", err)
+			os.Exit(4)
+		}
+		res.Summarize()
+		fmt.Println()
+	}
 
-	_startTime := time.Now()
-	walkTreeSummary(root, *summaryLimit, 0)
-	fmt.Printf("post scan report computer time: %v\n", time.Since(_startTime))
+	var quota *quotaReport
+	if *quotaFile != "" {
+		quota = newQuotaReport(quotaRules, res)
+		for _, p := range quota.MissingPaths {
+			fmt.Fprintln(os.Stderr, "quota path not found in the scan:", p)
+		}
+	}
+
+	if *textfile != "" {
+		if err := writeTextfileMetrics(*textfile, res, *metricsLimit); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing metrics textfile:", err)
+			os.Exit(4)
+		}
+	}
+
+	if *snapshotFile != "" {
+		if err := saveSnapshot(*snapshotFile, res); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing snapshot:", err)
+			os.Exit(4)
+		}
+	}
+
+	if *browse {
+		if err := browseTree(res, *cleanLog); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in browser:", err)
+			os.Exit(4)
+		}
+		return
+	}
+
+	if *format == "json" {
+		if err := writeJsonReport(os.Stdout, res, *jsonWithTree, quota); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing json:", err)
+			os.Exit(4)
+		}
+		exitForQuota(quota)
+		return
+	}
+
+	if *format == "csv" {
+		// csv only on stdout so it can be loaded as is - -D is the tree, otherwise the -R reports
+		if *dumpFullDetails {
+			treeWalkDetails(csvOut, selectedCols, res.Root, 0, &res.Start, *flatUnits)
+		} else {
+			csvReports(csvOut, res, *reports, *summaryLimit)
+		}
+		csvOut.Flush()
+		if err := csvOut.Error(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing csv:", err)
+			os.Exit(4)
+		}
+		if incomplete != nil {
+			fmt.Fprintf(os.Stderr, "INCOMPLETE SCAN: %v - %d directories not finished\n", incomplete, len(res.Unfinished))
+		}
+		if quota != nil {
+			// stdout is the one csv table so the violations go to stderr
+			printQuotaReport(os.Stderr, quota, *csvDelim)
+		}
+		exitForQuota(quota)
+		return
+	}
+
+	if incomplete != nil {
+		printIncompleteBanner(incomplete)
+	}
+	fmt.Printf("Scanned directory path: %s\n", *rootDir)
 
 	if *dumpFullDetails {
-		treeWalkDetails(root, 0, &start, *flatUnits)
+		treeWalkDetails(csvOut, selectedCols, res.Root, 0, &res.Start, *flatUnits)
+		csvOut.Flush()
+		if err := csvOut.Error(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing details:", err)
+			os.Exit(4)
+		}
 		fmt.Println()
-		reportAnyScanErrors()
+		reportAnyScanErrors(res)
+		if incomplete != nil {
+			printUnfinished(res.Unfinished, 0)
+		}
 	} else {
 		for _, x := range *reports {
 			switch x {
 			case 'l':
-				fmt.Println("Largest files (globally)")
-				maxFiles.mapMax.Descend(func(value PathSize) bool {
-					if *flatUnits {
-						fmt.Printf("%12d %s\n", uint64(value.size), value.path)
-					} else {
-						fmt.Printf("%8s %s\n", statticker.FormatBytes(uint64(value.size)), value.path)
-					}
-					return true
-				})
+				printSummary(res.LargestFiles, true, "Largest files (globally)", *flatUnits)
 			case 'i':
 				fmt.Println()
-				printSummary(maxDirByImmSize, true, "directories by total file size immediately in it", *flatUnits)
+				printSummary(res.DirsByImmSize, true, "directories by total file size immediately in it", *flatUnits)
 			case 'f':
 				fmt.Println()
-				printSummary(maxDirByImmCount, false, "directories by file count immediately in it", *flatUnits)
+				printSummary(res.DirsByImmFiles, false, "directories by file count immediately in it", *flatUnits)
 			case 'd':
 				fmt.Println()
-				printSummary(maxDirByRecSize, true, "directories by total file size recursively in it", *flatUnits)
+				printSummary(res.DirsByRecSize, true, "directories by total file size recursively in it", *flatUnits)
 			case 'r':
 				fmt.Println()
-				printSummary(maxDirByImmDirCount, false, "directories by directory count immediately in it", *flatUnits)
+				printSummary(res.DirsByImmDirs, false, "directories by directory count immediately in it", *flatUnits)
 			case 'u':
 				fmt.Println()
 				if !isWindows {
-					printUserInfo(*summaryLimit)
+					printUserInfo(res, *summaryLimit)
 				} else {
 					fmt.Println("user id not supported on windows")
 				}
+			case 'g':
+				fmt.Println()
+				if !isWindows {
+					printGroupInfo(res, *summaryLimit)
+				} else {
+					fmt.Println("group id not supported on windows")
+				}
+			case 'p':
+				fmt.Println()
+				if !isWindows {
+					printUserTops(res, *summaryLimit, *flatUnits)
+				} else {
+					fmt.Println("user id not supported on windows")
+				}
+			case 's':
+				fmt.Println()
+				printSummary(res.SparseFiles, true, "sparse files by apparent size beyond allocated size", *flatUnits)
+			case 'e':
+				fmt.Println()
+				printKindInfo(res, *summaryLimit, *flatUnits)
+			case 'a':
+				fmt.Println()
+				printAgeInfo(res, *summaryLimit, *flatUnits)
+			case 'o':
+				fmt.Println()
+				printSummary(res.StaleDirs, true, fmt.Sprintf("stale directories with no file %s newer than %s", res.Options.TimeField, formatDuration(res.Options.StaleAge, 2)), *flatUnits)
+			case 'h':
+				if opts.DedupHardLinks {
+					fmt.Println()
+					printSummary(res.DirsByRecShared, true, "directories by hard linked bytes shared with other directories recursively", *flatUnits)
+				}
 			default:
 				fmt.Printf("Unknown -R sub option: '%c' skipped\n", x)
 			}
 
 		}
-		fmt.Println("Total size:", statticker.FormatBytes(totalSize.Get()), "in",
-			statticker.AddCommas(countFiles.Get()), "files and", countDirs.Get(), "directories", "done in", elapse)
+		if res.Duplicates != nil {
+			fmt.Println()
+			printDupInfo(res.Duplicates, *summaryLimit, *flatUnits)
+		}
+		if len(res.Mounts) > 1 {
+			fmt.Println()
+			printMountSummary(res.Mounts, *flatUnits)
+			fmt.Println()
+		}
+		if len(res.Archives) > 0 {
+			fmt.Println()
+			printArchiveSummary(res.Archives, *summaryLimit, *flatUnits)
+			fmt.Println()
+		}
+		totals := &res.Totals
+		fmt.Println("Total size:", statticker.FormatBytes(totals.Size), "in",
+			statticker.AddCommas(totals.Files), "files and", totals.Dirs, "directories", "done in", elapse)
+		fmt.Println("Allocated size:", statticker.FormatBytes(totals.Allocated))
+		if totals.ExcludedFiles > 0 || totals.ExcludedDirs > 0 {
+			fmt.Println("Excluded:", statticker.FormatBytes(totals.ExcludedSize), "in",
+				statticker.AddCommas(totals.ExcludedFiles), "files and", totals.ExcludedDirs, "directories (excluded directories not read)")
+		}
+		if opts.DedupHardLinks {
+			fmt.Println("Hard link shared size:", statticker.FormatBytes(res.Root.RecShared), "in",
+				statticker.AddCommas(totals.HardLinkDups), "extra links not counted in total")
+		}
+		if incomplete != nil {
+			fmt.Println()
+			printIncompleteBanner(incomplete)
+			printUnfinished(res.Unfinished, *summaryLimit)
+		}
 	}
 
+	if quota != nil {
+		fmt.Println()
+		printQuotaReport(os.Stdout, quota, *csvDelim)
+	}
+	exitForQuota(quota)
 }
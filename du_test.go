@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// scanMemFS scans the tree under /r of fsys with opts
+func scanMemFS(t *testing.T, fsys *scan.MemFS, opts scan.Options) *scan.Result {
+	t.Helper()
+	opts.FS = fsys
+	res, err := scan.New(opts).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// captureStdout returns what print writes to stdout
+func captureStdout(t *testing.T, print func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	print()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done)
+}
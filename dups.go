@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+func printDupInfo(d *scan.Duplicates, limit int, flatUnits bool) {
+	size := func(n int64) string {
+		if flatUnits {
+			return fmt.Sprint(n)
+		}
+		return statticker.FormatBytes(uint64(n))
+	}
+	fmt.Printf("duplicate files: %s reclaimable in %d groups from %s files read",
+		size(d.Reclaimable), len(d.Groups), statticker.AddCommas(d.Hashed))
+	if d.ReadErrors > 0 {
+		fmt.Printf(", %d could not be read", d.ReadErrors)
+	}
+	fmt.Println()
+	for i, g := range d.Groups {
+		if i >= limit {
+			break
+		}
+		fmt.Printf("%8s reclaimable, %d copies of %s\n", size(g.Reclaimable()), len(g.Paths), size(g.Size))
+		for _, p := range g.Paths {
+			fmt.Println("    ", p)
+		}
+	}
+	fmt.Println()
+	printSummary(d.DirsByReclaimable, true, "directories by reclaimable bytes of duplicate copies in them", flatUnits)
+}
+
+// csvDups keys each group by its first path with the number of copies in
+// the files column
+func csvDups(w recordWriter, d *scan.Duplicates, limit int) {
+	for i, g := range d.Groups {
+		if i >= limit {
+			break
+		}
+		w.Write([]string{"dup_groups", strconv.Itoa(i + 1), g.Paths[0],
+			strconv.FormatInt(g.Reclaimable(), 10), strconv.Itoa(len(g.Paths)), ""})
+	}
+	csvSummary(w, d.DirsByReclaimable, "dup_dirs_by_reclaimable")
+}
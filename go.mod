@@ -1,4 +1,4 @@
-module du
+module github.com/sflanaga/du2go
 
 go 1.23.0
 
@@ -7,4 +7,8 @@ require (
 	github.com/puzpuzpuz/xsync/v3 v3.4.0
 	github.com/sflanaga/statticker v0.0.3
 	golang.org/x/sync v0.8.0
+	golang.org/x/sys v0.26.0
+	golang.org/x/term v0.25.0
 )
+
+require github.com/klauspost/compress v1.18.0
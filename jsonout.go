@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// jsonSchemaVersion must be bumped on any change that is not a pure addition
+// of new fields so downstream tools can tell what they are reading
+const jsonSchemaVersion = 1
+
+type jsonDocument struct {
+	SchemaVersion int           `json:"schema_version"`
+	Scan          jsonScanMeta  `json:"scan"`
+	Totals        jsonTotals    `json:"totals"`
+	Errors        jsonErrors    `json:"errors"`
+	Reports       jsonReports   `json:"reports"`
+	Users         []jsonUser    `json:"users"`
+	Groups        []jsonGroup   `json:"groups"`
+	UserTops      []jsonUserTop `json:"user_tops,omitempty"`
+	Mounts        []jsonMount   `json:"mounts,omitempty"`
+	Archives      []jsonArchive `json:"archives,omitempty"`
+	Kinds         *jsonKinds    `json:"kinds,omitempty"`
+	Ages          *jsonAges     `json:"ages,omitempty"`
+	Duplicates    *jsonDups     `json:"duplicates,omitempty"`
+	Quota         *quotaReport  `json:"quota,omitempty"`
+	Tree          *jsonDirInfo  `json:"tree,omitempty"`
+}
+
+type jsonScanMeta struct {
+	Root           string    `json:"root"`
+	StartTime      time.Time `json:"start_time"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	Threads        int       `json:"threads"`
+	Allocated      bool      `json:"allocated_sizes"`
+	DedupHardLinks bool      `json:"dedup_hard_links"`
+	OneFileSystem  bool      `json:"one_file_system"`
+	Complete       bool      `json:"complete"`
+	StopReason     string    `json:"stop_reason,omitempty"`
+	UnfinishedDirs []string  `json:"unfinished_dirs,omitempty"`
+}
+
+type jsonTotals struct {
+	Size          uint64 `json:"size"`
+	Allocated     uint64 `json:"allocated"`
+	Files         uint64 `json:"files"`
+	Dirs          uint64 `json:"dirs"`
+	SharedSize    uint64 `json:"hard_link_shared_size"`
+	ExcludedSize  uint64 `json:"excluded_size"`
+	ExcludedFiles uint64 `json:"excluded_files"`
+	ExcludedDirs  uint64 `json:"excluded_dirs"`
+}
+
+type jsonErrors struct {
+	FileStat       uint64            `json:"file_stat"`
+	DirList        uint64            `json:"dir_list"`
+	FilteredDirs   uint64            `json:"filtered_dirs"`
+	NotDirOrFile   uint64            `json:"not_dir_or_file"`
+	ByFileType     map[string]uint64 `json:"not_dir_or_file_by_type"`
+	SkippedMounts  []string          `json:"skipped_mounts"`
+	ExcludedMounts []string          `json:"excluded_mounts"`
+	HardLinkDups   uint64            `json:"hard_link_dups"`
+	Archives       uint64            `json:"unreadable_archives"`
+}
+
+type jsonPathSize struct {
+	Path  string `json:"path"`
+	Value int64  `json:"value"`
+}
+
+type jsonReports struct {
+	LargestFiles    []jsonPathSize `json:"largest_files"`
+	DirsByImmSize   []jsonPathSize `json:"dirs_by_imm_size"`
+	DirsByImmFiles  []jsonPathSize `json:"dirs_by_imm_files"`
+	DirsByImmDirs   []jsonPathSize `json:"dirs_by_imm_dirs"`
+	DirsByRecSize   []jsonPathSize `json:"dirs_by_rec_size"`
+	DirsByRecShared []jsonPathSize `json:"dirs_by_rec_shared"`
+	SparseFiles     []jsonPathSize `json:"sparse_files"`
+	StaleDirs       []jsonPathSize `json:"stale_dirs"`
+}
+
+type jsonUser struct {
+	Uid       uint32 `json:"uid"`
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Allocated uint64 `json:"allocated"`
+	Files     uint64 `json:"files"`
+	Dirs      uint64 `json:"dirs"`
+}
+
+// jsonKinds lists at most the top-N extensions overall and per top-level
+// directory but every category
+type jsonKinds struct {
+	ByCategory  []jsonCategory    `json:"by_category"`
+	ByExtension []jsonExtension   `json:"by_extension"`
+	ByTopDir    []jsonTopDirKinds `json:"by_top_dir"`
+}
+
+type jsonCategory struct {
+	Category  string `json:"category"`
+	Size      uint64 `json:"size"`
+	Allocated uint64 `json:"allocated"`
+	Files     uint64 `json:"files"`
+}
+
+type jsonExtension struct {
+	Ext string `json:"ext"`
+	jsonCategory
+}
+
+type jsonTopDirKinds struct {
+	Path        string          `json:"path"`
+	ByExtension []jsonExtension `json:"by_extension"`
+}
+
+func jsonExtensions(list []scan.KindStats, limit int) []jsonExtension {
+	out := make([]jsonExtension, 0, min(len(list), limit))
+	for _, k := range list[:min(len(list), limit)] {
+		out = append(out, jsonExtension{k.Ext, jsonCategory{k.Category, k.Size, k.Blocks, k.Files}})
+	}
+	return out
+}
+
+func jsonKindReport(res *scan.Result) *jsonKinds {
+	allocated, limit := res.Options.Allocated, res.Options.TopN
+	kinds := &jsonKinds{
+		ByCategory:  []jsonCategory{},
+		ByExtension: jsonExtensions(scan.GroupKinds(res.Kinds, false, allocated), limit),
+		ByTopDir:    []jsonTopDirKinds{},
+	}
+	for _, k := range scan.GroupKinds(res.Kinds, true, allocated) {
+		kinds.ByCategory = append(kinds.ByCategory, jsonCategory{k.Category, k.Size, k.Blocks, k.Files})
+	}
+	for _, top := range topDirsByKindUsage(res.KindsByTopDir) {
+		kinds.ByTopDir = append(kinds.ByTopDir, jsonTopDirKinds{
+			Path:        top,
+			ByExtension: jsonExtensions(scan.GroupKinds(res.KindsByTopDir[top], false, allocated), limit),
+		})
+	}
+	return kinds
+}
+
+// jsonAges lists at most the top-N users and top-level directories
+type jsonAges struct {
+	Time     string           `json:"time"`
+	Overall  []jsonAgeBucket  `json:"overall"`
+	ByUser   []jsonUserAges   `json:"by_user"`
+	ByTopDir []jsonTopDirAges `json:"by_top_dir"`
+}
+
+type jsonAgeBucket struct {
+	Age       string `json:"age"`
+	Size      uint64 `json:"size"`
+	Allocated uint64 `json:"allocated"`
+	Files     uint64 `json:"files"`
+}
+
+type jsonUserAges struct {
+	Uid     uint32          `json:"uid"`
+	Buckets []jsonAgeBucket `json:"buckets"`
+}
+
+type jsonTopDirAges struct {
+	Path    string          `json:"path"`
+	Buckets []jsonAgeBucket `json:"buckets"`
+}
+
+func jsonAgeBuckets(h scan.AgeHistogram) []jsonAgeBucket {
+	out := make([]jsonAgeBucket, 0, len(h))
+	for i, b := range h {
+		out = append(out, jsonAgeBucket{scan.AgeBucketNames[i], b.Size, b.Blocks, b.Files})
+	}
+	return out
+}
+
+func jsonAgeReport(res *scan.Result) *jsonAges {
+	limit := res.Options.TopN
+	ages := &jsonAges{
+		Time:     res.Options.TimeField.String(),
+		Overall:  jsonAgeBuckets(res.Ages),
+		ByUser:   []jsonUserAges{},
+		ByTopDir: []jsonTopDirAges{},
+	}
+	for i, uid := range byAgeUsage(res.AgesByUser) {
+		if i >= limit {
+			break
+		}
+		ages.ByUser = append(ages.ByUser, jsonUserAges{uid, jsonAgeBuckets(res.AgesByUser[uid])})
+	}
+	for i, top := range byAgeUsage(res.AgesByTopDir) {
+		if i >= limit {
+			break
+		}
+		ages.ByTopDir = append(ages.ByTopDir, jsonTopDirAges{top, jsonAgeBuckets(res.AgesByTopDir[top])})
+	}
+	return ages
+}
+
+type jsonArchive struct {
+	Path       string `json:"path"`
+	Format     string `json:"format"`
+	Compressed uint64 `json:"compressed_size"`
+	Size       uint64 `json:"size"`
+	Files      uint64 `json:"files"`
+}
+
+type jsonMount struct {
+	MountPoint string `json:"mount_point"`
+	FsType     string `json:"fs_type"`
+	Source     string `json:"source"`
+	Size       uint64 `json:"size"`
+	Files      uint64 `json:"files"`
+	Dirs       uint64 `json:"dirs"`
+	Capacity   uint64 `json:"capacity"`
+	Free       uint64 `json:"free"`
+}
+
+// jsonDirInfo times are unix seconds and null when there were no files
+type jsonDirInfo struct {
+	Path      string         `json:"path"`
+	ImmSize   uint64         `json:"imm_size"`
+	ImmBlocks uint64         `json:"imm_allocated"`
+	ImmFiles  uint64         `json:"imm_files"`
+	ImmDirs   uint64         `json:"imm_dirs"`
+	ImmOldest *int64         `json:"imm_oldest"`
+	ImmNewest *int64         `json:"imm_newest"`
+	RecSize   uint64         `json:"rec_size"`
+	RecBlocks uint64         `json:"rec_allocated"`
+	RecFiles  uint64         `json:"rec_files"`
+	RecDirs   uint64         `json:"rec_dirs"`
+	RecOldest *int64         `json:"rec_oldest"`
+	RecNewest *int64         `json:"rec_newest"`
+	RecShared uint64         `json:"rec_shared"`
+	Children  []*jsonDirInfo `json:"children,omitempty"`
+}
+
+func jsonTime(t int64) *int64 {
+	if t == math.MaxInt64 || t == math.MinInt64 {
+		return nil
+	}
+	return &t
+}
+
+func jsonTopN(list []scan.PathSize) []jsonPathSize {
+	out := make([]jsonPathSize, 0, len(list))
+	for _, value := range list {
+		out = append(out, jsonPathSize{Path: value.Path, Value: value.Size})
+	}
+	return out
+}
+
+// jsonDirNode is a single directory without its children
+func jsonDirNode(dir *scan.DirInfo) *jsonDirInfo {
+	return &jsonDirInfo{
+		Path:      dir.Name,
+		ImmSize:   dir.ImmSize,
+		ImmBlocks: dir.ImmBlocks,
+		ImmFiles:  dir.ImmFiles,
+		ImmDirs:   dir.ImmDirs,
+		ImmOldest: jsonTime(dir.ImmOldest),
+		ImmNewest: jsonTime(dir.ImmNewest),
+		RecSize:   dir.RecSize,
+		RecBlocks: dir.RecBlocks,
+		RecFiles:  dir.RecFiles,
+		RecDirs:   dir.RecDirs,
+		RecOldest: jsonTime(dir.RecOldest),
+		RecNewest: jsonTime(dir.RecNewest),
+		RecShared: dir.RecShared,
+	}
+}
+
+func jsonTree(dir *scan.DirInfo) *jsonDirInfo {
+	node := jsonDirNode(dir)
+	for _, child := range dir.Children {
+		node.Children = append(node.Children, jsonTree(child))
+	}
+	return node
+}
+
+func jsonUsers(res *scan.Result) []jsonUser {
+	list := res.UserList()
+	users := make([]jsonUser, 0, len(list))
+	for _, u := range list {
+		users = append(users, jsonUser{Uid: u.Uid, Name: idNames.User(u.Uid), Size: u.Size, Allocated: u.Blocks, Files: u.Files, Dirs: u.Dirs})
+	}
+	return users
+}
+
+// jsonUserTop is in the order of the users list
+type jsonUserTop struct {
+	Uid             uint32         `json:"uid"`
+	Name            string         `json:"name"`
+	LargestFiles    []jsonPathSize `json:"largest_files"`
+	DirsByOwnedSize []jsonPathSize `json:"dirs_by_owned_size"`
+}
+
+func jsonUserTops(res *scan.Result) []jsonUserTop {
+	tops := make([]jsonUserTop, 0, len(res.UserTops))
+	for _, u := range res.UserList() {
+		if top, ok := res.UserTops[u.Uid]; ok {
+			tops = append(tops, jsonUserTop{u.Uid, idNames.User(u.Uid), jsonTopN(top.LargestFiles), jsonTopN(top.DirsByOwnedSize)})
+		}
+	}
+	return tops
+}
+
+// jsonDups lists at most the top-N groups
+type jsonDups struct {
+	Reclaimable       uint64         `json:"reclaimable"`
+	Groups            []jsonDupGroup `json:"groups"`
+	DirsByReclaimable []jsonPathSize `json:"dirs_by_reclaimable"`
+	Hashed            uint64         `json:"files_read"`
+	ReadErrors        uint64         `json:"read_errors"`
+}
+
+type jsonDupGroup struct {
+	Size        uint64   `json:"size"`
+	Reclaimable uint64   `json:"reclaimable"`
+	Sha256      string   `json:"sha256"`
+	Paths       []string `json:"paths"`
+}
+
+func jsonDupReport(d *scan.Duplicates, limit int) *jsonDups {
+	dups := &jsonDups{
+		Reclaimable:       uint64(d.Reclaimable),
+		Groups:            []jsonDupGroup{},
+		DirsByReclaimable: jsonTopN(d.DirsByReclaimable),
+		Hashed:            d.Hashed,
+		ReadErrors:        d.ReadErrors,
+	}
+	for _, g := range d.Groups[:min(len(d.Groups), limit)] {
+		dups.Groups = append(dups.Groups, jsonDupGroup{uint64(g.Size), uint64(g.Reclaimable()), g.Hash, g.Paths})
+	}
+	return dups
+}
+
+type jsonGroup struct {
+	Gid       uint32 `json:"gid"`
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Allocated uint64 `json:"allocated"`
+	Files     uint64 `json:"files"`
+	Dirs      uint64 `json:"dirs"`
+}
+
+func jsonGroups(res *scan.Result) []jsonGroup {
+	list := res.GroupList()
+	groups := make([]jsonGroup, 0, len(list))
+	for _, g := range list {
+		groups = append(groups, jsonGroup{Gid: g.Gid, Name: idNames.Group(g.Gid), Size: g.Size, Allocated: g.Blocks, Files: g.Files, Dirs: g.Dirs})
+	}
+	return groups
+}
+
+// buildJsonDocument gathers the scan results
+func buildJsonDocument(res *scan.Result, withTree bool) *jsonDocument {
+	doc := &jsonDocument{
+		SchemaVersion: jsonSchemaVersion,
+		Scan: jsonScanMeta{
+			Root:           res.Root.Name,
+			StartTime:      res.Start,
+			ElapsedSeconds: res.Elapsed.Seconds(),
+			Threads:        res.Options.Threads,
+			Allocated:      res.Options.Allocated,
+			DedupHardLinks: res.Options.DedupHardLinks,
+			OneFileSystem:  res.Options.OneFileSystem,
+			Complete:       res.Incomplete == nil,
+		},
+		Totals: jsonTotals{
+			Size:          res.Totals.Size,
+			Allocated:     res.Totals.Allocated,
+			Files:         res.Totals.Files,
+			Dirs:          res.Totals.Dirs,
+			SharedSize:    res.Root.RecShared,
+			ExcludedSize:  res.Totals.ExcludedSize,
+			ExcludedFiles: res.Totals.ExcludedFiles,
+			ExcludedDirs:  res.Totals.ExcludedDirs,
+		},
+		Errors: jsonErrors{
+			FileStat:       res.Errors.FileStat,
+			DirList:        res.Errors.DirList,
+			FilteredDirs:   res.Errors.FilteredDirs,
+			NotDirOrFile:   res.Errors.NotDirOrFile,
+			ByFileType:     map[string]uint64{},
+			SkippedMounts:  append([]string{}, res.Errors.SkippedMounts...),
+			ExcludedMounts: append([]string{}, res.Errors.ExcludedMounts...),
+			HardLinkDups:   res.Totals.HardLinkDups,
+			Archives:       res.Errors.Archives,
+		},
+		Reports: jsonReports{
+			LargestFiles:    jsonTopN(res.LargestFiles),
+			DirsByImmSize:   jsonTopN(res.DirsByImmSize),
+			DirsByImmFiles:  jsonTopN(res.DirsByImmFiles),
+			DirsByImmDirs:   jsonTopN(res.DirsByImmDirs),
+			DirsByRecSize:   jsonTopN(res.DirsByRecSize),
+			DirsByRecShared: jsonTopN(res.DirsByRecShared),
+			SparseFiles:     jsonTopN(res.SparseFiles),
+		},
+		Users:  jsonUsers(res),
+		Groups: jsonGroups(res),
+	}
+	if res.Incomplete != nil {
+		doc.Scan.StopReason = res.Incomplete.Error()
+		doc.Scan.UnfinishedDirs = res.Unfinished
+	}
+	for name, n := range res.Errors.ByFileType {
+		doc.Errors.ByFileType[name] = n
+	}
+	for _, m := range res.Mounts {
+		doc.Mounts = append(doc.Mounts, jsonMount{
+			MountPoint: m.MountPoint, FsType: m.FsType, Source: m.Source,
+			Size: m.Size, Files: m.Files, Dirs: m.Dirs, Capacity: m.Capacity, Free: m.Free,
+		})
+	}
+	for _, a := range res.Archives {
+		doc.Archives = append(doc.Archives, jsonArchive{
+			Path: a.Path, Format: a.Format, Compressed: a.Compressed, Size: a.Size, Files: a.Files,
+		})
+	}
+	// stale_dirs is null unless -R o asked for it
+	if res.Options.StaleAge > 0 {
+		doc.Reports.StaleDirs = jsonTopN(res.StaleDirs)
+	}
+	if res.Options.Categories != nil {
+		doc.Kinds = jsonKindReport(res)
+	}
+	if res.Duplicates != nil {
+		doc.Duplicates = jsonDupReport(res.Duplicates, res.Options.TopN)
+	}
+	if res.Options.UserTops {
+		doc.UserTops = jsonUserTops(res)
+	}
+	if res.Options.AgeHistogram {
+		doc.Ages = jsonAgeReport(res)
+	}
+	if withTree {
+		doc.Tree = jsonTree(res.Root)
+	}
+	return doc
+}
+
+// writeJsonReport emits the whole scan as one document with the -quota
+// outcome when there was a policy
+func writeJsonReport(w io.Writer, res *scan.Result, withTree bool, quota *quotaReport) error {
+	doc := buildJsonDocument(res, withTree)
+	doc.Quota = quota
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
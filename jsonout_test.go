@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func TestJsonReport(t *testing.T) {
+	defer func(n *scan.Names) { idNames = n }(idNames)
+	idNames = scan.NewNames()
+	if err := idNames.LoadPasswd(strings.NewReader("alice:x:1001:200::/:/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/a/big", scan.MemFile{Size: 3000, Allocated: 4096, Uid: 1001, Gid: 200})
+	fsys.Add("/r/a/small", scan.MemFile{Size: 10, Allocated: 4096, Uid: 1001, Gid: 200})
+	fsys.Add("/r/link", scan.MemFile{Mode: fs.ModeSymlink})
+	fsys.Add("/r/fifo", scan.MemFile{Mode: fs.ModeNamedPipe})
+	fsys.Add("/r/fifo2", scan.MemFile{Mode: fs.ModeNamedPipe})
+	res := scanMemFS(t, fsys, scan.Options{TopN: 5})
+	var buf bytes.Buffer
+	if err := writeJsonReport(&buf, res, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the top level keys are the contract, optional ones only when asked for
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"schema_version", "scan", "totals", "errors", "reports", "users", "groups", "tree"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("no %q in %s", key, buf.String())
+		}
+	}
+	for _, key := range []string{"user_tops", "kinds", "ages", "duplicates", "quota"} {
+		if _, ok := raw[key]; ok {
+			t.Errorf("%q without its option", key)
+		}
+	}
+	if !strings.Contains(string(raw["reports"]), `"stale_dirs": null`) {
+		t.Errorf("stale dirs without a stale age %s", raw["reports"])
+	}
+
+	var doc jsonDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SchemaVersion != jsonSchemaVersion || doc.Scan.Root != "/r" || !doc.Scan.Complete || !doc.Scan.StartTime.Equal(res.Start) {
+		t.Errorf("scan %d %+v", doc.SchemaVersion, doc.Scan)
+	}
+	if doc.Totals.Size != 3010 || doc.Totals.Allocated != 8192 || doc.Totals.Files != 2 || doc.Totals.Dirs != 1 {
+		t.Errorf("totals %+v", doc.Totals)
+	}
+	if doc.Errors.NotDirOrFile != 3 || doc.Errors.ByFileType["pipe"] != 2 || doc.Errors.ByFileType["symlink"] != 1 {
+		t.Errorf("errors %+v", doc.Errors)
+	}
+	if len(doc.Reports.LargestFiles) != 2 || doc.Reports.LargestFiles[0] != (jsonPathSize{"/r/a/big", 3000}) {
+		t.Errorf("largest files %+v", doc.Reports.LargestFiles)
+	}
+	if len(doc.Users) != 2 || doc.Users[0] != (jsonUser{1001, "alice", 3010, 8192, 2, 0}) {
+		t.Errorf("users %+v", doc.Users)
+	}
+	if doc.Tree == nil || len(doc.Tree.Children) != 1 || doc.Tree.Children[0].Path != "/r/a" || doc.Tree.RecFiles != 2 {
+		t.Fatalf("tree %+v", doc.Tree)
+	}
+	// no files means no times rather than a sentinel
+	if !strings.Contains(string(raw["tree"]), `"imm_oldest": null`) || doc.Tree.RecNewest == nil {
+		t.Errorf("tree times %s", raw["tree"])
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+// loadCategories is the -categories file or the built in defaults
+func loadCategories(fname string) (*scan.Categories, error) {
+	if fname == "" {
+		return scan.NewDefaultCategories(), nil
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scan.ParseCategories(f)
+}
+
+func extLabel(ext string) string {
+	if ext == "" {
+		return "(none)"
+	}
+	return ext
+}
+
+func printKindList(list []scan.KindStats, limit int, withExt bool, flatUnits bool, indent string) {
+	for i, k := range list {
+		if i >= limit {
+			break
+		}
+		size := statticker.FormatBytes(k.Usage(useAllocated))
+		if flatUnits {
+			size = fmt.Sprint(k.Usage(useAllocated))
+		}
+		if withExt {
+			fmt.Printf("%s%12s %10s %-12s %s\n", indent, size, statticker.AddCommas(k.Files), extLabel(k.Ext), k.Category)
+		} else {
+			fmt.Printf("%s%12s %10s %s\n", indent, size, statticker.AddCommas(k.Files), k.Category)
+		}
+	}
+}
+
+// topDirsByKindUsage orders the top-level directories by what their files
+// add up to, largest first
+func topDirsByKindUsage(byTop map[string][]scan.KindStats) []string {
+	usage := map[string]uint64{}
+	for top, list := range byTop {
+		for _, k := range list {
+			usage[top] += k.Usage(useAllocated)
+		}
+	}
+	tops := make([]string, 0, len(usage))
+	for top := range usage {
+		tops = append(tops, top)
+	}
+	slices.SortFunc(tops, func(a, b string) int {
+		if c := cmp.Compare(usage[b], usage[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return tops
+}
+
+func printKindInfo(res *scan.Result, limit int, flatUnits bool) {
+	fmt.Println("file usage by category")
+	fmt.Printf("%12s %10s %s\n", "Space", "Files", "Category")
+	printKindList(scan.GroupKinds(res.Kinds, true, useAllocated), limit, false, flatUnits, "")
+	fmt.Println()
+	fmt.Println("file usage by extension")
+	fmt.Printf("%12s %10s %-12s %s\n", "Space", "Files", "Extension", "Category")
+	printKindList(scan.GroupKinds(res.Kinds, false, useAllocated), limit, true, flatUnits, "")
+	fmt.Println()
+	fmt.Println("file usage by extension per top-level directory")
+	for i, top := range topDirsByKindUsage(res.KindsByTopDir) {
+		if i >= limit {
+			break
+		}
+		fmt.Println(top)
+		printKindList(scan.GroupKinds(res.KindsByTopDir[top], false, useAllocated), limit, true, flatUnits, "    ")
+	}
+	fmt.Println()
+}
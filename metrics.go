@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// metrics are written in the prometheus text exposition format by hand - it
+// is a handful of gauges and not worth a client library dependency
+
+type metricWriter struct {
+	w    *bufio.Writer
+	seen map[string]bool
+}
+
+func newMetricWriter(w io.Writer) *metricWriter {
+	return &metricWriter{w: bufio.NewWriter(w), seen: make(map[string]bool)}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// gauge writes one sample - labels are name, value pairs.  HELP and TYPE are
+// only written the first time a metric name is seen.
+func (m *metricWriter) gauge(name string, help string, value float64, labels ...string) {
+	if !m.seen[name] {
+		m.seen[name] = true
+		fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+func (m *metricWriter) flush() error {
+	return m.w.Flush()
+}
+
+// errorMetrics are shared by the live and final output
+func (m *metricWriter) errorMetrics(root string, errs scan.Errors) {
+	const name, help = "du2go_scan_errors", "Scan error and skip counters by type"
+	m.gauge(name, help, float64(errs.FileStat), "root", root, "type", "file_stat")
+	m.gauge(name, help, float64(errs.DirList), "root", root, "type", "dir_list")
+	m.gauge(name, help, float64(errs.FilteredDirs), "root", root, "type", "filtered_dirs")
+	m.gauge(name, help, float64(errs.NotDirOrFile), "root", root, "type", "not_dir_or_file")
+	m.gauge(name, help, float64(len(errs.SkippedMounts)), "root", root, "type", "skipped_mounts")
+	m.gauge(name, help, float64(errs.Archives), "root", root, "type", "unreadable_archives")
+	for _, t := range slices.Sorted(maps.Keys(errs.ByFileType)) {
+		m.gauge("du2go_scan_not_dir_or_file", "Entries skipped as neither file nor directory by file type", float64(errs.ByFileType[t]), "root", root, "file_type", t)
+	}
+}
+
+func (m *metricWriter) progressMetrics(root string, scanner *scan.Scanner) {
+	running := 0.0
+	if scanner.Running() {
+		running = 1
+	}
+	m.gauge("du2go_scan_running", "1 while a scan is in progress", running, "root", root)
+	m.gauge("du2go_scan_bytes", "Bytes counted so far in the current or last scan", float64(scanner.Progress.Size.Get()), "root", root)
+	m.gauge("du2go_scan_allocated_bytes", "Allocated bytes counted so far in the current or last scan", float64(scanner.Progress.Allocated.Get()), "root", root)
+	m.gauge("du2go_scan_files", "Files counted so far in the current or last scan", float64(scanner.Progress.Files.Get()), "root", root)
+	m.gauge("du2go_scan_dirs", "Directories counted so far in the current or last scan", float64(scanner.Progress.Dirs.Get()), "root", root)
+	m.gauge("du2go_scan_goroutines", "Walker goroutines running", float64(scanner.Progress.Goroutines.Get()), "root", root)
+}
+
+// liveMetricsHandler serves the statticker stats while the scan runs
+func liveMetricsHandler(root string, scanner *scan.Scanner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m := newMetricWriter(w)
+		m.progressMetrics(root, scanner)
+		m.errorMetrics(root, scanner.Errors())
+		m.flush()
+	}
+}
+
+// startMetricsServer serves /metrics in the background for the life of the process
+func startMetricsServer(addr string, root string, scanner *scan.Scanner) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", liveMetricsHandler(root, scanner))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintln(os.Stderr, "metrics server failed:", err)
+		}
+	}()
+}
+
+// writeFinalMetrics has the totals, the top users and the top directories by
+// rec size - limit caps the label cardinality and the remaining users are
+// summed under uid="other"
+func writeFinalMetrics(w io.Writer, res *scan.Result, limit int) error {
+	m := newMetricWriter(w)
+	root := res.Root
+	complete := 1.0
+	if res.Incomplete != nil {
+		complete = 0
+	}
+	m.gauge("du2go_scan_complete", "1 when the last scan finished without being stopped", complete, "root", root.Name)
+	m.gauge("du2go_scan_duration_seconds", "Wall time of the last scan", res.Elapsed.Seconds(), "root", root.Name)
+	m.gauge("du2go_scan_timestamp_seconds", "Unix time the last scan finished", float64(time.Now().Unix()), "root", root.Name)
+	m.gauge("du2go_total_bytes", "Total apparent bytes under the root", float64(res.Totals.Size), "root", root.Name)
+	m.gauge("du2go_total_allocated_bytes", "Total allocated bytes under the root", float64(res.Totals.Allocated), "root", root.Name)
+	m.gauge("du2go_total_files", "Total files under the root", float64(res.Totals.Files), "root", root.Name)
+	m.gauge("du2go_total_dirs", "Total directories under the root", float64(res.Totals.Dirs), "root", root.Name)
+
+	users := res.UserList()
+	var other scan.UserStats
+	for i, u := range users {
+		if i >= limit {
+			other.Size += u.Size
+			other.Blocks += u.Blocks
+			other.Files += u.Files
+			other.Dirs += u.Dirs
+			continue
+		}
+		uid := strconv.FormatUint(uint64(u.Uid), 10)
+		m.gauge("du2go_user_bytes", "Bytes owned by user id", float64(u.Usage(res.Options.Allocated)), "root", root.Name, "uid", uid)
+		m.gauge("du2go_user_files", "Files owned by user id", float64(u.Files), "root", root.Name, "uid", uid)
+		m.gauge("du2go_user_dirs", "Directories owned by user id", float64(u.Dirs), "root", root.Name, "uid", uid)
+	}
+	if len(users) > limit {
+		m.gauge("du2go_user_bytes", "Bytes owned by user id", float64(other.Usage(res.Options.Allocated)), "root", root.Name, "uid", "other")
+		m.gauge("du2go_user_files", "Files owned by user id", float64(other.Files), "root", root.Name, "uid", "other")
+		m.gauge("du2go_user_dirs", "Directories owned by user id", float64(other.Dirs), "root", root.Name, "uid", "other")
+	}
+
+	for _, d := range topDirsByRecUsage(root, res.Options.Allocated, limit) {
+		m.gauge("du2go_dir_rec_bytes", "Top directories by bytes recursively under them", float64(d.Size), "root", root.Name, "path", d.Path)
+	}
+	m.errorMetrics(root.Name, res.Errors)
+	return m.flush()
+}
+
+// topDirsByRecUsage walks the tree itself rather than using the -R trees so the
+// metrics do not depend on which reports ran
+func topDirsByRecUsage(root *scan.DirInfo, allocated bool, limit int) []scan.PathSize {
+	var top []scan.PathSize
+	var walk func(dir *scan.DirInfo)
+	walk = func(dir *scan.DirInfo) {
+		size := int64(dir.RecUsage(allocated))
+		if len(top) < limit || size > top[len(top)-1].Size {
+			i, _ := slices.BinarySearchFunc(top, size, func(p scan.PathSize, size int64) int {
+				return cmpBool(p.Size < size, p.Size > size)
+			})
+			top = slices.Insert(top, i, scan.PathSize{Path: dir.Name, Size: size})
+			if len(top) > limit {
+				top = top[:limit]
+			}
+		}
+		for _, child := range dir.Children {
+			walk(child)
+		}
+	}
+	if limit > 0 {
+		walk(root)
+	}
+	return top
+}
+
+// writeTextfileMetrics writes for the node_exporter textfile collector - the
+// temp file and rename keep the collector from reading a half written file
+func writeTextfileMetrics(fname string, res *scan.Result, limit int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), ".du2go-*.prom")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeFinalMetrics(tmp, res, limit); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fname)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func TestMetricLabelEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMetricWriter(&buf)
+	m.gauge("x", "help", 1.5, "path", "a\"b\\c\nd")
+	m.gauge("x", "help", 2, "path", "e")
+	m.flush()
+	want := "# HELP x help\n# TYPE x gauge\n" +
+		`x{path="a\"b\\c\nd"} 1.5` + "\n" +
+		`x{path="e"} 2` + "\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestFinalMetrics(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/a/big", scan.MemFile{Size: 5000})
+	fsys.Add("/r/a/b/small", scan.MemFile{Size: 100})
+	fsys.Add("/r/c/medium", scan.MemFile{Size: 2000})
+	s, _ := newTestServer(t, fsys)
+	if err := s.rescan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeFinalMetrics(&buf, s.latest.res, 2); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		`du2go_scan_complete{root="/r"} 1`,
+		`du2go_total_bytes{root="/r"} 7100`,
+		`du2go_total_files{root="/r"} 3`,
+		`du2go_dir_rec_bytes{root="/r",path="/r"} 7100`,
+		`du2go_dir_rec_bytes{root="/r",path="/r/a"} 5100`,
+		`du2go_scan_errors{root="/r",type="dir_list"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	// limit of 2 leaves out the third largest directory
+	if strings.Contains(out, "/r/c") {
+		t.Errorf("dir metrics not limited:\n%s", out)
+	}
+}
+
+func TestLiveMetricsEndpoint(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/f", scan.MemFile{Size: 100})
+	s := newScanServer("/r", scan.Options{FS: fsys, Threads: 4, TopN: 10}, 0)
+	if err := s.rescan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `du2go_scan_running{root="/r"} 0`) ||
+		!strings.Contains(string(body), `du2go_scan_bytes{root="/r"} 100`) {
+		t.Errorf("unexpected metrics\n%s", body)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+)
+
+func parseFsTypeList(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			set[t] = true
+		}
+	}
+	return set
+}
+
+func printMountSummary(list []*scan.MountInfo, flatUnits bool) {
+	fmt.Println("Usage per mount (capacity and free from statfs)")
+	fmt.Printf("%12s %12s %12s %12s %-12s %s\n", "Scanned", "Files", "Capacity", "Free", "Type", "Mount")
+	for _, m := range list {
+		if flatUnits {
+			fmt.Printf("%12d %12d %12d %12d %-12s %s\n", m.Size, m.Files, m.Capacity, m.Free, m.FsType, m.MountPoint)
+		} else {
+			fmt.Printf("%12s %12s %12s %12s %-12s %s\n", statticker.FormatBytes(m.Size), statticker.AddCommas(m.Files),
+				statticker.FormatBytes(m.Capacity), statticker.FormatBytes(m.Free), m.FsType, m.MountPoint)
+		}
+	}
+}
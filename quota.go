@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// exit codes of a scan whose -quota policy was broken - soft limits only
+// warn, any hard limit is critical
+const (
+	quotaExitWarning  = 5
+	quotaExitCritical = 6
+)
+
+const (
+	quotaWarning  = "warning"
+	quotaCritical = "critical"
+)
+
+// quotaRule is one line of a -quota policy file.  A limit of 0 is not set.
+type quotaRule struct {
+	line   int
+	kind   string // path, user or group
+	target string
+	id     uint32
+	metric string
+	soft   uint64
+	hard   uint64
+}
+
+// quotaMetrics are the metrics of each kind of rule and whether their limits
+// are sizes like 10G or plain counts
+var quotaMetrics = map[string]map[string]bool{
+	"path":  {"rec_size": true, "rec_allocated": true, "rec_files": false, "rec_dirs": false},
+	"user":  {"size": true, "allocated": true, "files": false, "dirs": false},
+	"group": {"size": true, "allocated": true, "files": false, "dirs": false},
+}
+
+// parseQuotaPolicy reads one rule per line as "kind target metric soft hard"
+// where kind is path, user or group, a user or group is a name or id and a
+// limit of - is not set.  Paths may have spaces and relative ones are under
+// root.  Blank lines and # comments are ignored.
+func parseQuotaPolicy(r io.Reader, root string) ([]quotaRule, error) {
+	var rules []quotaRule
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %d: expected \"kind target metric soft hard\"", lineNo)
+		}
+		n := len(fields)
+		rule := quotaRule{line: lineNo, kind: fields[0], target: strings.Join(fields[1:n-3], " "), metric: fields[n-3]}
+		metrics, ok := quotaMetrics[rule.kind]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown kind '%s' - must be path, user or group", lineNo, rule.kind)
+		}
+		isSize, ok := metrics[rule.metric]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown %s metric '%s'", lineNo, rule.kind, rule.metric)
+		}
+		var err error
+		if rule.soft, err = parseQuotaLimit(fields[n-2], isSize); err != nil {
+			return nil, fmt.Errorf("line %d: soft limit: %w", lineNo, err)
+		}
+		if rule.hard, err = parseQuotaLimit(fields[n-1], isSize); err != nil {
+			return nil, fmt.Errorf("line %d: hard limit: %w", lineNo, err)
+		}
+		if rule.soft == 0 && rule.hard == 0 {
+			return nil, fmt.Errorf("line %d: no soft or hard limit", lineNo)
+		}
+		if rule.soft > 0 && rule.hard > 0 && rule.soft > rule.hard {
+			return nil, fmt.Errorf("line %d: soft limit is above the hard limit", lineNo)
+		}
+		switch rule.kind {
+		case "path":
+			if !filepath.IsAbs(rule.target) {
+				rule.target = filepath.Join(root, rule.target)
+			}
+			rule.target = filepath.Clean(rule.target)
+		case "user":
+			rule.id, err = idNames.UserId(rule.target)
+		case "group":
+			rule.id, err = idNames.GroupId(rule.target)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseQuotaLimit(s string, isSize bool) (uint64, error) {
+	if s == "-" {
+		return 0, nil
+	}
+	if isSize {
+		return parseSize(s)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err == nil && n == 0 {
+		err = errors.New("a limit of 0 - use - for no limit")
+	}
+	return n, err
+}
+
+func loadQuotaPolicy(fname string, root string) ([]quotaRule, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseQuotaPolicy(f, root)
+}
+
+// quotaViolation is a rule over its soft or hard limit - Id is the uid or gid
+// and 0 for a path
+type quotaViolation struct {
+	Level  string `json:"level"`
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Id     uint32 `json:"id"`
+	Metric string `json:"metric"`
+	Value  uint64 `json:"value"`
+	Limit  uint64 `json:"limit"`
+	Line   int    `json:"policy_line"`
+}
+
+// value is what the rule measures - false when its path was not scanned
+func (rule *quotaRule) value(res *scan.Result) (uint64, bool) {
+	switch rule.kind {
+	case "path":
+		dir := res.Root.Find(rule.target)
+		if dir == nil {
+			return 0, false
+		}
+		switch rule.metric {
+		case "rec_size":
+			return dir.RecSize, true
+		case "rec_allocated":
+			return dir.RecBlocks, true
+		case "rec_files":
+			return dir.RecFiles, true
+		default:
+			return dir.RecDirs, true
+		}
+	case "user":
+		u := res.Users[rule.id]
+		return pickQuotaMetric(rule.metric, u.Size, u.Blocks, u.Files, u.Dirs), true
+	default:
+		g := res.Groups[rule.id]
+		return pickQuotaMetric(rule.metric, g.Size, g.Blocks, g.Files, g.Dirs), true
+	}
+}
+
+func pickQuotaMetric(metric string, size, allocated, files, dirs uint64) uint64 {
+	switch metric {
+	case "size":
+		return size
+	case "allocated":
+		return allocated
+	case "files":
+		return files
+	default:
+		return dirs
+	}
+}
+
+// checkQuotas evaluates every rule and lists the broken ones in policy order
+// - a hard limit hides the soft one of the same rule.  Paths that were not
+// scanned are listed in missing.
+func checkQuotas(rules []quotaRule, res *scan.Result) (violations []quotaViolation, missing []string) {
+	for i := range rules {
+		rule := &rules[i]
+		v, ok := rule.value(res)
+		if !ok {
+			missing = append(missing, rule.target)
+			continue
+		}
+		level, limit := "", uint64(0)
+		if rule.hard > 0 && v > rule.hard {
+			level, limit = quotaCritical, rule.hard
+		} else if rule.soft > 0 && v > rule.soft {
+			level, limit = quotaWarning, rule.soft
+		}
+		if level == "" {
+			continue
+		}
+		violations = append(violations, quotaViolation{
+			Level: level, Kind: rule.kind, Target: rule.target, Id: rule.id,
+			Metric: rule.metric, Value: v, Limit: limit, Line: rule.line,
+		})
+	}
+	return violations, missing
+}
+
+// quotaReport is the outcome of a whole policy - also its json form
+type quotaReport struct {
+	// Status is ok, warning or critical
+	Status       string           `json:"status"`
+	Violations   []quotaViolation `json:"violations"`
+	MissingPaths []string         `json:"missing_paths,omitempty"`
+}
+
+func newQuotaReport(rules []quotaRule, res *scan.Result) *quotaReport {
+	q := &quotaReport{Status: "ok", Violations: []quotaViolation{}}
+	violations, missing := checkQuotas(rules, res)
+	q.Violations = append(q.Violations, violations...)
+	q.MissingPaths = missing
+	for _, v := range violations {
+		q.Status = v.Level
+		if v.Level == quotaCritical {
+			break
+		}
+	}
+	return q
+}
+
+// exitCode is 0 when nothing was broken
+func (q *quotaReport) exitCode() int {
+	switch q.Status {
+	case quotaCritical:
+		return quotaExitCritical
+	case quotaWarning:
+		return quotaExitWarning
+	}
+	return 0
+}
+
+// writeQuotaViolations is the machine readable section - one header row and
+// one row per violation
+func writeQuotaViolations(w recordWriter, violations []quotaViolation) {
+	w.Write([]string{"level", "kind", "target", "id", "metric", "value", "limit", "policy_line"})
+	for _, v := range violations {
+		id := ""
+		if v.Kind != "path" {
+			id = strconv.FormatUint(uint64(v.Id), 10)
+		}
+		w.Write([]string{v.Level, v.Kind, v.Target, id, v.Metric,
+			strconv.FormatUint(v.Value, 10), strconv.FormatUint(v.Limit, 10), strconv.Itoa(v.Line)})
+	}
+}
+
+// printQuotaReport writes a status line and the violations as a table in the
+// -csv-delim format
+func printQuotaReport(w io.Writer, q *quotaReport, delim string) {
+	fmt.Fprintf(w, "QUOTA %s: %d violations\n", strings.ToUpper(q.Status), len(q.Violations))
+	if len(q.Violations) == 0 {
+		return
+	}
+	// the delimiter was already checked when the flags were read
+	out, _ := newRecordWriter(w, delim)
+	writeQuotaViolations(out, q.Violations)
+	out.Flush()
+}
+
+// exitForQuota ends a scan whose policy was broken with its exit code
+func exitForQuota(q *quotaReport) {
+	if q != nil && q.exitCode() != 0 {
+		os.Exit(q.exitCode())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func quotaTestResult(t *testing.T) *scan.Result {
+	t.Helper()
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/proj/a", scan.MemFile{Size: 3000, Allocated: 4096, Uid: 1001, Gid: 200})
+	fsys.Add("/r/proj/b", scan.MemFile{Size: 500, Allocated: 4096, Uid: 1001, Gid: 200})
+	fsys.Add("/r/scratch dir/c", scan.MemFile{Size: 10, Uid: 0, Gid: 0})
+	return scanMemFS(t, fsys, scan.Options{TopN: 5})
+}
+
+func TestParseQuotaPolicy(t *testing.T) {
+	defer func(n *scan.Names) { idNames = n }(idNames)
+	idNames = scan.NewNames()
+	if err := idNames.LoadPasswd(strings.NewReader("alice:x:1001:200::/:/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	policy := "# comment\n\npath proj rec_size 2K 4K\npath /r/scratch dir rec_files - 5\nuser alice files 1 -\ngroup 200 allocated 1K 2k\n"
+	rules, err := parseQuotaPolicy(strings.NewReader(policy), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("rules %+v", rules)
+	}
+	if rules[0].target != "/r/proj" || rules[0].soft != 2048 || rules[0].hard != 4096 || rules[0].line != 3 {
+		t.Errorf("path rule %+v", rules[0])
+	}
+	if rules[1].target != "/r/scratch dir" || rules[1].soft != 0 || rules[1].hard != 5 {
+		t.Errorf("path with a space %+v", rules[1])
+	}
+	if rules[2].id != 1001 || rules[2].soft != 1 || rules[2].hard != 0 {
+		t.Errorf("user rule %+v", rules[2])
+	}
+	if rules[3].id != 200 || rules[3].hard != 2048 {
+		t.Errorf("group rule %+v", rules[3])
+	}
+
+	for _, bad := range []string{
+		"path /r rec_size 1G\n",
+		"disk /r rec_size 1G 2G\n",
+		"path /r files 1 2\n",
+		"user alice size 2G 1G\n",
+		"user alice size - -\n",
+		"user mallory size 1G 2G\n",
+		"group 200 files 1.5 2\n",
+	} {
+		if _, err := parseQuotaPolicy(strings.NewReader(bad), "/r"); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	res := quotaTestResult(t)
+	rules, err := parseQuotaPolicy(strings.NewReader(
+		"path /r/proj rec_size 2K 4K\n"+
+			"path /r/proj rec_files 1 2\n"+
+			"path /r/gone rec_size 1 2\n"+
+			"user 0 size 100 -\n"+
+			"group 200 allocated 1K 8K\n"), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newQuotaReport(rules, res)
+	if len(q.Violations) != 3 || q.Status != quotaWarning || q.exitCode() != quotaExitWarning {
+		t.Fatalf("quota %+v", q)
+	}
+	if v := q.Violations[0]; v.Target != "/r/proj" || v.Metric != "rec_size" || v.Value != 3500 || v.Limit != 2048 || v.Level != quotaWarning {
+		t.Errorf("path violation %+v", v)
+	}
+	if v := q.Violations[2]; v.Kind != "group" || v.Id != 200 || v.Value != 8192 {
+		t.Errorf("group violation %+v", v)
+	}
+	if len(q.MissingPaths) != 1 || q.MissingPaths[0] != "/r/gone" {
+		t.Errorf("missing %v", q.MissingPaths)
+	}
+
+	rules = append(rules, quotaRule{kind: "user", id: 1001, metric: "files", hard: 1})
+	q = newQuotaReport(rules, res)
+	if q.Status != quotaCritical || q.exitCode() != quotaExitCritical || q.Violations[3].Level != quotaCritical {
+		t.Errorf("quota with a hard limit %+v", q)
+	}
+
+	if q := newQuotaReport(nil, res); q.Status != "ok" || q.exitCode() != 0 {
+		t.Errorf("empty policy %+v", q)
+	}
+}
@@ -0,0 +1,181 @@
+package scan
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// TimeField is which file timestamp ages and the oldest and newest times of
+// directories are taken from
+type TimeField int
+
+const (
+	TimeMtime TimeField = iota
+	TimeAtime
+	TimeCtime
+	// TimeBirth needs an FS that is a BirthTimer - files without one fall
+	// back to the mtime
+	TimeBirth
+)
+
+var timeFieldNames = [...]string{"mtime", "atime", "ctime", "birth"}
+
+func (f TimeField) String() string {
+	return timeFieldNames[f]
+}
+
+// ParseTimeField takes mtime, atime, ctime or birth
+func ParseTimeField(name string) (TimeField, error) {
+	for i, n := range timeFieldNames {
+		if n == name {
+			return TimeField(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown time '%s' - must be mtime, atime, ctime or birth", name)
+}
+
+// fileTime is the unix seconds of the field time of a file
+func fileTime(field TimeField, fsys FS, path string, info fs.FileInfo, sys FileSys) int64 {
+	switch field {
+	case TimeAtime:
+		return sys.Atime
+	case TimeCtime:
+		return sys.Ctime
+	case TimeBirth:
+		if bt, ok := fsys.(BirthTimer); ok {
+			if t, err := bt.BirthTime(path); err == nil {
+				return t.Unix()
+			}
+		}
+	}
+	return info.ModTime().Unix()
+}
+
+// checkBirthTime fails early instead of silently using mtimes throughout
+func (s *Scanner) checkBirthTime(root string) error {
+	bt, ok := s.fsys.(BirthTimer)
+	if !ok {
+		return fmt.Errorf("birth time is not available from %T", s.fsys)
+	}
+	if _, err := bt.BirthTime(root); err != nil {
+		return fmt.Errorf("birth time is not available for %s: %w", root, err)
+	}
+	return nil
+}
+
+const day = 24 * time.Hour
+
+// ageLimits are the upper ends of all but the last AgeHistogram bucket
+var ageLimits = [...]time.Duration{day, 7 * day, 30 * day, 182 * day, 365 * day, 3 * 365 * day}
+
+// AgeBucketNames label the buckets of an AgeHistogram
+var AgeBucketNames = [...]string{"<1d", "<1w", "<1m", "<6m", "<1y", "<3y", "older"}
+
+// AgeBucket is the files in one age range
+type AgeBucket struct {
+	Size   uint64
+	Blocks uint64
+	Files  uint64
+}
+
+// Usage is the apparent or allocated bytes
+func (b *AgeBucket) Usage(allocated bool) uint64 {
+	if allocated {
+		return b.Blocks
+	}
+	return b.Size
+}
+
+// AgeHistogram is files by age at the start of the scan - see AgeBucketNames.
+// Times in the future count as the youngest.
+type AgeHistogram [len(AgeBucketNames)]AgeBucket
+
+func (h *AgeHistogram) add(o *AgeHistogram) {
+	for i := range h {
+		h[i].Size += o[i].Size
+		h[i].Blocks += o[i].Blocks
+		h[i].Files += o[i].Files
+	}
+}
+
+func (h *AgeHistogram) sub(o *AgeHistogram) {
+	for i := range h {
+		h[i].Size = subClamp(h[i].Size, o[i].Size)
+		h[i].Blocks = subClamp(h[i].Blocks, o[i].Blocks)
+		h[i].Files = subClamp(h[i].Files, o[i].Files)
+	}
+}
+
+// Total of all the buckets
+func (h *AgeHistogram) Total() AgeBucket {
+	var t AgeBucket
+	for _, b := range h {
+		t.Size += b.Size
+		t.Blocks += b.Blocks
+		t.Files += b.Files
+	}
+	return t
+}
+
+func ageBucket(age time.Duration) int {
+	for i, limit := range ageLimits {
+		if age < limit {
+			return i
+		}
+	}
+	return len(ageLimits)
+}
+
+// ageTally accumulates one directory like kindTally
+type ageTally struct {
+	s      *Scanner
+	top    string
+	all    AgeHistogram
+	byUser map[uint32]*AgeHistogram
+}
+
+func (t *ageTally) addFile(uid uint32, ftime int64, size, blocks uint64) {
+	i := ageBucket(time.Duration(t.s.start.Unix()-ftime) * time.Second)
+	h := t.byUser[uid]
+	if h == nil {
+		h = &AgeHistogram{}
+		t.byUser[uid] = h
+	}
+	for _, b := range []*AgeBucket{&t.all[i], &h[i]} {
+		b.Size += size
+		b.Blocks += blocks
+		b.Files++
+	}
+}
+
+func (t *ageTally) flush() {
+	if t.all.Total().Files == 0 {
+		return
+	}
+	t.s.agesByTop.Compute(t.top, func(old AgeHistogram, loaded bool) (AgeHistogram, bool) {
+		old.add(&t.all)
+		return old, false
+	})
+	for uid, h := range t.byUser {
+		t.s.agesByUser.Compute(uid, func(old AgeHistogram, loaded bool) (AgeHistogram, bool) {
+			old.add(h)
+			return old, false
+		})
+	}
+}
+
+// ageResults turns the tallies into the Result histograms
+func (s *Scanner) ageResults(res *Result) {
+	res.AgesByTopDir = map[string]AgeHistogram{}
+	res.AgesByUser = map[uint32]AgeHistogram{}
+	s.agesByTop.Range(func(top string, h AgeHistogram) bool {
+		res.AgesByTopDir[top] = h
+		res.Ages.add(&h)
+		return true
+	})
+	s.agesByUser.Range(func(uid uint32, h AgeHistogram) bool {
+		res.AgesByUser[uid] = h
+		return true
+	})
+}
@@ -0,0 +1,112 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTimeField(t *testing.T) {
+	for _, name := range []string{"mtime", "atime", "ctime", "birth"} {
+		f, err := ParseTimeField(name)
+		if err != nil || f.String() != name {
+			t.Errorf("%s: got %v %v", name, f, err)
+		}
+	}
+	if _, err := ParseTimeField("xtime"); err == nil {
+		t.Error("no error for an unknown time")
+	}
+}
+
+func TestAgeBucket(t *testing.T) {
+	for age, want := range map[time.Duration]int{
+		-time.Hour: 0, 0: 0, 23 * time.Hour: 0, day: 1, 6 * day: 1, 8 * day: 2,
+		31 * day: 3, 200 * day: 4, 2 * 365 * day: 5, 3 * 365 * day: 6, 40 * 365 * day: 6,
+	} {
+		if got := ageBucket(age); got != want {
+			t.Errorf("%v: got %s want %s", age, AgeBucketNames[got], AgeBucketNames[want])
+		}
+	}
+}
+
+func agesTree(now time.Time) *MemFS {
+	fsys := NewMemFS()
+	fsys.Add("/r/new.txt", MemFile{Size: 1, Allocated: 4096, ModTime: now.Add(-time.Hour), Uid: 1})
+	fsys.Add("/r/a/week.txt", MemFile{Size: 20, ModTime: now.Add(-3 * day), Atime: now.Add(-time.Minute), Uid: 1})
+	fsys.Add("/r/a/old/x.bin", MemFile{Size: 300, ModTime: now.Add(-400 * day), Uid: 2})
+	fsys.Add("/r/a/old/deep/y.bin", MemFile{Size: 4000, ModTime: now.Add(-5 * 365 * day), Btime: now.Add(-2 * day), Uid: 2})
+	fsys.Add("/r/b/z.bin", MemFile{Size: 50000, ModTime: now.Add(-200 * day), Uid: 2})
+	fsys.Add("/r/c/recent.txt", MemFile{Size: 7, ModTime: now.Add(-10 * day), Uid: 1})
+	return fsys
+}
+
+func TestScanAges(t *testing.T) {
+	fsys := agesTree(time.Now())
+	res, err := New(Options{FS: fsys, Threads: 2, TopN: 10, AgeHistogram: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AgeHistogram{{1, 4096, 1}, {20, 0, 1}, {7, 0, 1}, {}, {50000, 0, 1}, {300, 0, 1}, {4000, 0, 1}}
+	if res.Ages != want {
+		t.Errorf("ages %v want %v", res.Ages, want)
+	}
+	if total := res.Ages.Total(); total.Size != res.Totals.Size || total.Files != res.Totals.Files {
+		t.Errorf("ages total %+v does not match totals %+v", total, res.Totals)
+	}
+	if u := res.AgesByUser[2]; u[5].Size != 300 || u[6].Size != 4000 || u.Total().Files != 3 {
+		t.Errorf("ages of uid 2 %v", u)
+	}
+	if u := res.AgesByUser[1]; u.Total().Size != 28 {
+		t.Errorf("ages of uid 1 %v", u)
+	}
+	if len(res.AgesByTopDir) != 4 {
+		t.Errorf("top dirs %v", res.AgesByTopDir)
+	}
+	if a := res.AgesByTopDir["/r/a"]; a[1].Size != 20 || a[5].Size != 300 || a[6].Size != 4000 {
+		t.Errorf("ages of /r/a %v", a)
+	}
+	if r := res.AgesByTopDir["/r"]; r.Total().Files != 1 {
+		t.Errorf("ages of the root itself %v", r)
+	}
+}
+
+func TestScanAgesByTime(t *testing.T) {
+	fsys := agesTree(time.Now())
+	res, err := New(Options{FS: fsys, Threads: 2, AgeHistogram: true, TimeField: TimeAtime}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Ages[0].Files != 2 || res.Ages[1].Files != 0 {
+		t.Errorf("atime ages %v", res.Ages)
+	}
+	res, err = New(Options{FS: fsys, Threads: 2, AgeHistogram: true, TimeField: TimeBirth}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Ages[1].Files != 2 || res.Ages[6].Files != 0 {
+		t.Errorf("birth time ages %v", res.Ages)
+	}
+	// the 5 year old mtime of y.bin is replaced by its birth time
+	if oldest := time.Unix(res.Root.RecOldest, 0); time.Since(oldest) > 401*day {
+		t.Errorf("oldest birth time %v", oldest)
+	}
+}
+
+func TestStaleDirs(t *testing.T) {
+	fsys := agesTree(time.Now())
+	res, err := New(Options{FS: fsys, Threads: 2, TopN: 10, StaleAge: 180 * day}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// /r/a/old/deep is stale but inside the stale /r/a/old
+	if got := paths(res.StaleDirs); len(got) != 2 || got[0] != "/r/b=50000" || got[1] != "/r/a/old=4300" {
+		t.Errorf("stale %v", got)
+	}
+	res, err = New(Options{FS: fsys, Threads: 2, TopN: 10}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.StaleDirs) != 0 {
+		t.Errorf("stale without StaleAge %v", paths(res.StaleDirs))
+	}
+}
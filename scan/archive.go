@@ -0,0 +1,328 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveInfo is one archive file read as a directory tree.  Inside an
+// archive the allocated bytes of a file are its compressed bytes - exact for
+// zip and spread at the granularity the decompressor reads for tar streams.
+type ArchiveInfo struct {
+	Path   string
+	Format string
+	// Compressed is the size of the archive file and Size the uncompressed
+	// total of the files in it
+	Compressed uint64
+	Size       uint64
+	Files      uint64
+}
+
+var archiveSuffixes = []struct{ suffix, format string }{
+	{".tar", "tar"},
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.zst", "tar.zst"},
+	{".tzst", "tar.zst"},
+	{".zip", "zip"},
+}
+
+// ArchiveFormat is tar, tar.gz, tar.zst or zip going by the file name and ""
+// for anything else
+func ArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	for _, a := range archiveSuffixes {
+		if strings.HasSuffix(name, a.suffix) {
+			return a.format
+		}
+	}
+	return ""
+}
+
+// openArchive reads the whole index of an archive into a MemFS rooted at
+// the archive's own path.  Every archive gets its own device so hard links
+// inside one never match inodes of another.  Once it is walked the caller
+// records it with addArchive.
+func (s *Scanner) openArchive(ctx context.Context, path string, info fs.FileInfo, sys FileSys) (FS, ArchiveInfo, error) {
+	b := &archiveBuilder{
+		fs:    NewMemFS(),
+		root:  path,
+		dev:   1<<62 | s.archiveDevs.Add(1),
+		owner: sys.Uid,
+		group: sys.Gid,
+		info:  ArchiveInfo{Path: path, Format: ArchiveFormat(path), Compressed: uint64(info.Size())},
+	}
+	b.fs.Add(path, MemFile{Mode: fs.ModeDir, ModTime: info.ModTime(), Uid: sys.Uid, Gid: sys.Gid, Dev: b.dev})
+	var err error
+	if b.info.Format == "zip" {
+		err = b.readZip()
+	} else {
+		err = b.readTarFile(ctx)
+	}
+	if err != nil {
+		return nil, ArchiveInfo{}, fmt.Errorf("reading archive %s: %w", path, err)
+	}
+	b.tally()
+	return archiveFS{b.fs}, b.info, nil
+}
+
+func (s *Scanner) addArchive(info ArchiveInfo) {
+	s.listMtx.Lock()
+	s.archives = append(s.archives, info)
+	s.listMtx.Unlock()
+}
+
+// archiveFS is the tree of one archive - mounts and -x do not apply in it
+type archiveFS struct {
+	*MemFS
+}
+
+func inArchive(fsys FS) bool {
+	_, ok := fsys.(archiveFS)
+	return ok
+}
+
+type archiveBuilder struct {
+	fs    *MemFS
+	root  string
+	dev   uint64
+	owner uint32
+	group uint32
+	info  ArchiveInfo
+}
+
+// add puts one entry under the archive root - names are cleaned so nothing
+// like ../ can land outside of it.  Directories only implied by the names of
+// entries below them belong to the owner and group of the archive.
+func (b *archiveBuilder) add(name string, f MemFile) *MemFile {
+	p := filepath.Join(b.root, filepath.FromSlash(path.Clean("/"+name)))
+	if p == b.root {
+		return nil
+	}
+	b.mkdirs(filepath.Dir(p))
+	f.Dev = b.dev
+	// a tar can hold a name more than once and extracting it leaves the last
+	// one - the bytes of the earlier ones are still in the archive though
+	if old, ok := b.fs.entries[p]; ok && old.Mode.IsRegular() {
+		f.Allocated += old.Allocated
+	}
+	b.fs.Add(p, f)
+	return b.fs.entries[p]
+}
+
+// tally sets the size and file count of what extracting the archive gives -
+// names replaced by a later entry are gone and hard links add no data
+func (b *archiveBuilder) tally() {
+	seen := make(map[uint64]bool)
+	for _, f := range b.fs.entries {
+		if f.Mode.IsRegular() && !seen[f.Ino] {
+			seen[f.Ino] = true
+			b.info.Size += uint64(f.Size)
+			b.info.Files++
+		}
+	}
+}
+
+func (b *archiveBuilder) mkdirs(dir string) {
+	if _, ok := b.fs.entries[dir]; ok {
+		return
+	}
+	b.mkdirs(filepath.Dir(dir))
+	b.fs.Add(dir, MemFile{Mode: fs.ModeDir, Uid: b.owner, Gid: b.group, Dev: b.dev})
+}
+
+func (b *archiveBuilder) readTarFile(ctx context.Context) error {
+	f, err := os.Open(b.root)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	raw := &countReader{r: f}
+	switch b.info.Format {
+	case "tar":
+		return b.readTar(ctx, raw, raw)
+	case "tar.gz":
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return b.readTar(ctx, gz, raw)
+	case "tar.zst":
+		// one goroutine is plenty since the walk already runs archives in parallel
+		zr, err := zstd.NewReader(raw, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return b.readTar(ctx, zr, raw)
+	}
+	return fmt.Errorf("unknown archive format %q", b.info.Format)
+}
+
+// readTar adds every header of the tar stream in r.  raw counts the bytes
+// read from the archive file and what it moved by since the last regular
+// file is charged to that file as its compressed size.
+func (b *archiveBuilder) readTar(ctx context.Context, r io.Reader, raw *countReader) error {
+	tr := tar.NewReader(r)
+	var last *MemFile
+	var lastPos, carry int64
+	for {
+		hdr, err := tr.Next()
+		pos := raw.n.Load()
+		if last != nil {
+			last.Allocated += pos - lastPos
+		} else {
+			carry += pos - lastPos
+		}
+		lastPos = pos
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// access and change times are only in PAX and GNU headers
+		f := MemFile{
+			Mode:    hdr.FileInfo().Mode().Type(),
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime,
+			Atime:   hdr.AccessTime,
+			Ctime:   hdr.ChangeTime,
+			Uid:     uint32(hdr.Uid),
+			Gid:     uint32(hdr.Gid),
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeLink:
+			// a hard link has no data of its own - it shares the inode of its target
+			target := filepath.Join(b.root, filepath.FromSlash(path.Clean("/"+hdr.Linkname)))
+			if t, ok := b.fs.entries[target]; ok && t.Mode.IsRegular() {
+				f.Size, f.Ino = t.Size, t.Ino
+			}
+		}
+		if !f.Mode.IsRegular() {
+			f.Size = 0
+		}
+		if added := b.add(hdr.Name, f); added != nil && added.Mode.IsRegular() {
+			added.Allocated += carry
+			carry = 0
+			last = added
+		}
+	}
+}
+
+func (b *archiveBuilder) readZip() error {
+	zr, err := zip.OpenReader(b.root)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		f := MemFile{
+			Mode:    zf.Mode().Type(),
+			ModTime: zf.Modified,
+			Uid:     b.owner,
+			Gid:     b.group,
+		}
+		if uid, gid, ok := zipOwner(zf.Extra); ok {
+			f.Uid, f.Gid = uid, gid
+		}
+		if f.Mode.IsRegular() {
+			f.Size = int64(zf.UncompressedSize64)
+			f.Allocated = int64(zf.CompressedSize64)
+		}
+		if f.ModTime.IsZero() {
+			f.ModTime = time.Unix(0, 0)
+		}
+		b.add(zf.Name, f)
+	}
+	return nil
+}
+
+// zipOwner finds the owner and group in the Info-ZIP unix extra field
+// (0x7875) that zip on unix writes
+func zipOwner(extra []byte) (uid, gid uint32, ok bool) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		// version, uid size, uid, gid size, gid
+		if tag != 0x7875 || len(field) < 2 || field[0] != 1 {
+			continue
+		}
+		uid, field, ok = zipId(field[1:])
+		if !ok {
+			continue
+		}
+		if gid, _, ok = zipId(field); !ok {
+			continue
+		}
+		return uid, gid, true
+	}
+	return 0, 0, false
+}
+
+// zipId reads one size prefixed little endian id and returns what follows it
+func zipId(field []byte) (uint32, []byte, bool) {
+	if len(field) < 1 {
+		return 0, nil, false
+	}
+	n := int(field[0])
+	if n == 0 || n > 8 || len(field) < 1+n {
+		return 0, nil, false
+	}
+	id := slices.Clone(field[1 : 1+n])
+	id = append(id, make([]byte, 8-n)...)
+	return uint32(binary.LittleEndian.Uint64(id)), field[1+n:], true
+}
+
+// countReader counts what is read through it.  It can seek when what it
+// wraps can, which lets the tar reader skip file data of a plain tar.
+type countReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countReader) Seek(offset int64, whence int) (int64, error) {
+	sk, ok := c.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("not seekable")
+	}
+	pos, err := sk.Seek(offset, whence)
+	if err == nil {
+		c.n.Store(pos)
+	}
+	return pos, err
+}
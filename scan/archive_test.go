@@ -0,0 +1,315 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArchiveFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.tar": "tar", "a.TGZ": "tar.gz", "a.tar.gz": "tar.gz", "a.tar.zst": "tar.zst",
+		"a.tzst": "tar.zst", "a.zip": "zip", "a.gz": "", "tar": "", "a.tar.bak": "",
+	} {
+		if got := ArchiveFormat(name); got != want {
+			t.Errorf("%s: got %q want %q", name, got, want)
+		}
+	}
+}
+
+// testTar has an explicit directory, one only implied by a name, a hard
+// link and a symlink
+func testTar(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Unix(1700000000, 0)
+	for _, h := range []tar.Header{
+		{Name: "proj/", Typeflag: tar.TypeDir, Uid: 5, Mode: 0755, ModTime: mtime},
+		{Name: "proj/a.txt", Typeflag: tar.TypeReg, Uid: 5, Size: 1000, Mode: 0644, ModTime: mtime},
+		{Name: "proj/sub/b.bin", Typeflag: tar.TypeReg, Uid: 6, Size: 300, Mode: 0644, ModTime: mtime.Add(time.Hour)},
+		{Name: "proj/link", Typeflag: tar.TypeLink, Linkname: "proj/a.txt", Uid: 5, Mode: 0644, ModTime: mtime},
+		{Name: "proj/sym", Typeflag: tar.TypeSymlink, Linkname: "a.txt", Uid: 5, Mode: 0777, ModTime: mtime},
+		{Name: "../escape", Typeflag: tar.TypeReg, Uid: 5, Size: 1, Mode: 0644, ModTime: mtime},
+	} {
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(make([]byte, h.Size))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanTarRoot(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "backup.tar")
+	data := testTar(t)
+	writeFile(t, tarPath, data)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	tgzPath := filepath.Join(dir, "backup.tgz")
+	writeFile(t, tgzPath, gz.Bytes())
+	zstPath := filepath.Join(dir, "backup.tar.zst")
+	zstdw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, zstPath, zstdw.EncodeAll(data, nil))
+
+	for _, root := range []string{tarPath, tgzPath, zstPath} {
+		res, err := New(Options{TopN: 5}).Scan(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the link counts in full like a hard link on disk does
+		if got := res.Totals; got.Size != 2301 || got.Files != 4 || got.Dirs != 2 {
+			t.Errorf("%s: totals %+v", root, got)
+		}
+		if res.Errors.ByFileType["symlink"] != 1 {
+			t.Errorf("%s: errors %+v", root, res.Errors)
+		}
+		proj := res.Root.Find(filepath.Join(root, "proj"))
+		sub := res.Root.Find(filepath.Join(root, "proj", "sub"))
+		if proj == nil || sub == nil || proj.Uid != 5 || sub.Uid != uint32(os.Getuid()) {
+			t.Fatalf("%s: tree %+v %+v", root, proj, sub)
+		}
+		if sub.ImmNewest != 1700003600 || proj.RecOldest != 1700000000 {
+			t.Errorf("%s: times %d %d", root, sub.ImmNewest, proj.RecOldest)
+		}
+		if res.Users[5].Size != 2001 || res.Users[6].Size != 300 {
+			t.Errorf("%s: users %+v", root, res.Users)
+		}
+		// names with ../ stay inside the archive
+		if res.Root.ImmFiles != 1 || res.LargestFiles[0].Path != filepath.Join(root, "proj", "link") &&
+			res.LargestFiles[0].Path != filepath.Join(root, "proj", "a.txt") {
+			t.Errorf("%s: root files %d largest %v", root, res.Root.ImmFiles, res.LargestFiles)
+		}
+		info, _ := os.Stat(root)
+		// the hard link adds no data to what extracting the archive gives
+		if len(res.Archives) != 1 || res.Archives[0].Compressed != uint64(info.Size()) || res.Archives[0].Size != 1301 ||
+			res.Archives[0].Files != 3 {
+			t.Errorf("%s: archives %+v", root, res.Archives)
+		}
+		if res.Totals.Allocated == 0 || res.Totals.Allocated > uint64(info.Size()) {
+			t.Errorf("%s: compressed bytes %d of %d", root, res.Totals.Allocated, info.Size())
+		}
+
+		res, err = New(Options{DedupHardLinks: true}).Scan(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Totals.Size != 1301 || res.Totals.HardLinkDups != 1 {
+			t.Errorf("%s: dedup totals %+v", root, res.Totals)
+		}
+	}
+}
+
+// extracting a tar leaves the last entry of a name so only that one counts
+func TestTarRepeatedName(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range []tar.Header{
+		{Name: "a.txt", Typeflag: tar.TypeReg, Size: 1000, Mode: 0644},
+		{Name: "b.txt", Typeflag: tar.TypeReg, Size: 10, Mode: 0644},
+		{Name: "./a.txt", Typeflag: tar.TypeReg, Size: 300, Mode: 0644},
+	} {
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(make([]byte, h.Size))
+	}
+	tw.Close()
+	root := filepath.Join(t.TempDir(), "twice.tar")
+	writeFile(t, root, buf.Bytes())
+
+	res, err := New(Options{TopN: 5}).Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Totals; got.Size != 310 || got.Files != 2 {
+		t.Errorf("totals %+v", got)
+	}
+	if len(res.Archives) != 1 || res.Archives[0].Size != 310 || res.Archives[0].Files != 2 {
+		t.Errorf("archives %+v", res.Archives)
+	}
+	// the bytes of both copies are still in the archive
+	if a := res.LargestFiles[0]; a.Path != filepath.Join(root, "a.txt") || a.Size != 300 || res.Totals.Allocated <= 1300 {
+		t.Errorf("largest %v allocated %d", res.LargestFiles, res.Totals.Allocated)
+	}
+}
+
+func TestScanZipRoot(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	stored, _ := zw.CreateHeader(&zip.FileHeader{Name: "docs/raw.bin", Method: zip.Store, Modified: time.Unix(1700000000, 0)})
+	stored.Write(make([]byte, 5000))
+	deflated, _ := zw.Create("docs/zeros.txt")
+	deflated.Write(make([]byte, 100000))
+	zw.Create("empty/")
+	zw.Close()
+	root := filepath.Join(t.TempDir(), "docs.zip")
+	writeFile(t, root, buf.Bytes())
+
+	res, err := New(Options{TopN: 5, Allocated: true}).Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Totals; got.Size != 105000 || got.Files != 2 || got.Dirs != 2 {
+		t.Errorf("totals %+v", got)
+	}
+	// allocated is the exact compressed size of each entry
+	raw := res.Root.Find(filepath.Join(root, "docs"))
+	if raw == nil || raw.ImmBlocks <= 5000 || raw.ImmBlocks > 6000 {
+		t.Errorf("compressed size of docs %+v", raw)
+	}
+	if res.LargestFiles[0].Path != filepath.Join(root, "docs", "raw.bin") {
+		t.Errorf("largest compressed %v", res.LargestFiles)
+	}
+	if u, ok := res.Users[uint32(os.Getuid())]; !ok || u.Files != 2 {
+		t.Errorf("zip without unix extra belongs to the archive owner %+v", res.Users)
+	}
+}
+
+func TestDescendIntoArchives(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "backup.tar"), testTar(t))
+	writeFile(t, filepath.Join(dir, "broken.zip"), []byte("not a zip"))
+	writeFile(t, filepath.Join(dir, "plain"), make([]byte, 10))
+
+	res, err := New(Options{}).Scan(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Totals.Files != 3 || res.Totals.Dirs != 0 || len(res.Archives) != 0 {
+		t.Errorf("without Archives %+v", res.Totals)
+	}
+
+	res, err = New(Options{Archives: true}).Scan(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the broken zip and the plain file plus the four files inside the tar
+	if res.Totals.Files != 6 || res.Totals.Dirs != 3 || res.Errors.Archives != 1 {
+		t.Errorf("with Archives %+v %+v", res.Totals, res.Errors)
+	}
+	tarDir := res.Root.Find(filepath.Join(dir, "backup.tar"))
+	if tarDir == nil || tarDir.RecSize != 2301 || tarDir.RecDirs != 2 {
+		t.Errorf("archive directory %+v", tarDir)
+	}
+	if len(res.Archives) != 1 || res.Archives[0].Format != "tar" {
+		t.Errorf("archives %+v", res.Archives)
+	}
+}
+
+// an archive inside an archive is only a file - it has no path on disk
+func TestNestedArchive(t *testing.T) {
+	var inner bytes.Buffer
+	zw := zip.NewWriter(&inner)
+	w, _ := zw.Create("x.txt")
+	w.Write(make([]byte, 50))
+	zw.Close()
+	var outer bytes.Buffer
+	tw := tar.NewWriter(&outer)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"inner.zip", inner.Bytes()}, {"inner.tar", testTar(t)}} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Size: int64(len(f.data)), Mode: 0644})
+		tw.Write(f.data)
+	}
+	tw.Close()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "outer.tar"), outer.Bytes())
+
+	var errs []string
+	opts := Options{Archives: true, Hooks: Hooks{OnError: func(path string, err error, depth int) { errs = append(errs, path) }}}
+	res, err := New(opts).Scan(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Errors.Archives != 0 || len(errs) != 0 {
+		t.Errorf("errors %d %v", res.Errors.Archives, errs)
+	}
+	if res.Totals.Files != 2 || res.Totals.Dirs != 1 || len(res.Archives) != 1 {
+		t.Errorf("totals %+v archives %+v", res.Totals, res.Archives)
+	}
+	a := res.Root.Find(filepath.Join(dir, "outer.tar"))
+	if a == nil || a.Uid != uint32(os.Getuid()) || a.Gid != uint32(os.Getgid()) {
+		t.Errorf("archive directory %+v", a)
+	}
+}
+
+// an archive is a directory or a file to the hooks, never both
+func TestArchiveHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "walked.tar"), testTar(t))
+	writeFile(t, filepath.Join(dir, "pruned.tar"), testTar(t))
+	writeFile(t, filepath.Join(dir, "broken.zip"), []byte("not a zip"))
+
+	var mtx sync.Mutex
+	var dirs, files []string
+	opts := Options{Archives: true, Hooks: Hooks{
+		OnDir: func(path string, entry fs.DirEntry, depth int) bool {
+			if depth == 1 {
+				mtx.Lock()
+				dirs = append(dirs, entry.Name())
+				mtx.Unlock()
+			}
+			return entry.Name() != "pruned.tar"
+		},
+		OnFile: func(path string, info fs.FileInfo, depth int) {
+			if depth == 1 {
+				mtx.Lock()
+				files = append(files, info.Name())
+				mtx.Unlock()
+			}
+		},
+	}}
+	res, err := New(opts).Scan(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dirs)
+	slices.Sort(files)
+	if !slices.Equal(dirs, []string{"pruned.tar", "walked.tar"}) || !slices.Equal(files, []string{"broken.zip", "pruned.tar"}) {
+		t.Errorf("dirs %v files %v", dirs, files)
+	}
+	// the four files in the walked tar plus the two archives counted as files
+	if res.Root.ImmFiles != 2 || res.Totals.Files != 6 || len(res.Archives) != 1 || res.Archives[0].Path != filepath.Join(dir, "walked.tar") {
+		t.Errorf("totals %+v archives %+v", res.Totals, res.Archives)
+	}
+}
+
+func TestZipOwner(t *testing.T) {
+	// an unrelated field, then version 1 with a 4 byte uid and a 2 byte gid
+	extra := []byte{0x55, 0x54, 1, 0, 0, 0x75, 0x78, 9, 0, 1, 4, 0xe9, 0x03, 0, 0, 2, 0xd0, 0x07}
+	if uid, gid, ok := zipOwner(extra); !ok || uid != 1001 || gid != 2000 {
+		t.Errorf("got %d %d %v", uid, gid, ok)
+	}
+	if _, _, ok := zipOwner(extra[:len(extra)-1]); ok {
+		t.Error("owner from a truncated field")
+	}
+}
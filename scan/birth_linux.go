@@ -0,0 +1,22 @@
+//go:build linux
+
+package scan
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// BirthTime comes from statx - an error when the filesystem does not keep it
+func (OSFS) BirthTime(path string) (time.Time, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx); err != nil {
+		return time.Time{}, err
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, errors.New("no birth time on this filesystem")
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package scan
+
+import (
+	"errors"
+	"time"
+)
+
+// BirthTime is only supported through statx on linux
+func (OSFS) BirthTime(path string) (time.Time, error) {
+	return time.Time{}, errors.New("birth time is only supported on linux")
+}
@@ -0,0 +1,258 @@
+package scan
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/btree"
+	"golang.org/x/sync/semaphore"
+)
+
+// dupPartialSize is how much of the start of a file the first pass hashes -
+// files no larger than it are done after that pass
+const dupPartialSize = 4096
+
+// Opener is an FS whose files can be read - needed for Options.Duplicates
+type Opener interface {
+	Open(path string) (io.ReadCloser, error)
+}
+
+func (OSFS) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// DupGroup is files with the same content.  Paths are sorted and the first
+// is taken as the one to keep.
+type DupGroup struct {
+	Size  int64
+	Hash  string
+	Paths []string
+}
+
+// Reclaimable is the bytes freed by keeping only one of the files
+func (g *DupGroup) Reclaimable() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// Duplicates is what the duplicate pass of Options.Duplicates found.  Sizes
+// are apparent sizes.
+type Duplicates struct {
+	// Groups are largest Reclaimable first
+	Groups      []DupGroup
+	Reclaimable int64
+	// DirsByReclaimable are the directories holding the most bytes of copies
+	// beyond the first of each group, at most Options.TopN
+	DirsByReclaimable []PathSize
+	// Hashed is the files read, each counted once even when read again in
+	// full after the partial hash, and ReadErrors the ones that could not be -
+	// those are left out of the groups
+	Hashed     uint64
+	ReadErrors uint64
+}
+
+// dupFile is one regular file that could have a copy
+type dupFile struct {
+	path string
+	size int64
+	id   fileId
+	hash string
+	read bool
+}
+
+// dupTally collects the files of one directory like kindTally
+type dupTally struct {
+	s     *Scanner
+	files map[int64][]*dupFile
+}
+
+func (t *dupTally) addFile(path string, size int64, sys FileSys) {
+	if size < max(t.s.opts.DupMinSize, 1) {
+		return
+	}
+	t.files[size] = append(t.files[size], &dupFile{path: path, size: size, id: fileId{sys.Dev, sys.Ino}})
+}
+
+func (t *dupTally) flush() {
+	for size, files := range t.files {
+		t.s.dupFiles.Compute(size, func(old []*dupFile, loaded bool) ([]*dupFile, bool) {
+			return append(old, files...), false
+		})
+	}
+}
+
+// findDuplicates narrows the files down by size, then by a hash of their
+// first block and then by a hash of everything
+func (s *Scanner) findDuplicates(ctx context.Context, opener Opener) *Duplicates {
+	d := &Duplicates{}
+	var groups [][]*dupFile
+	s.dupFiles.Range(func(size int64, files []*dupFile) bool {
+		if files = uniqueInodes(files); len(files) > 1 {
+			groups = append(groups, files)
+		}
+		return true
+	})
+
+	s.hashFiles(ctx, opener, groups, dupPartialSize, d)
+	var done, large [][]*dupFile
+	for _, files := range splitByHash(groups) {
+		if files[0].size <= dupPartialSize {
+			done = append(done, files)
+		} else {
+			large = append(large, files)
+		}
+	}
+	s.hashFiles(ctx, opener, large, -1, d)
+	done = append(done, splitByHash(large)...)
+
+	for _, files := range done {
+		g := DupGroup{Size: files[0].size, Hash: files[0].hash}
+		for _, f := range files {
+			g.Paths = append(g.Paths, f.path)
+		}
+		slices.Sort(g.Paths)
+		d.Groups = append(d.Groups, g)
+	}
+	d.summarize(s.opts.TopN)
+	return d
+}
+
+// summarize sorts the groups and works out the totals and the directory list
+// from them
+func (d *Duplicates) summarize(limit int) {
+	dirs := map[string]int64{}
+	d.Reclaimable = 0
+	for _, g := range d.Groups {
+		for _, p := range g.Paths[1:] {
+			dirs[filepath.Dir(p)] += g.Size
+		}
+		d.Reclaimable += g.Reclaimable()
+	}
+	slices.SortFunc(d.Groups, func(a, b DupGroup) int {
+		if c := cmp.Compare(b.Reclaimable(), a.Reclaimable()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Paths[0], b.Paths[0])
+	})
+	top := btree.NewG[PathSize](16, PathSizeLess)
+	for dir, size := range dirs {
+		TrySetNewMaxPath(top, size, dir, limit)
+	}
+	d.DirsByReclaimable = Descending(top)
+}
+
+// remove drops the files at or under path, and with them the groups left with
+// a single file
+func (d *Duplicates) remove(path string, limit int) {
+	groups := d.Groups[:0]
+	for _, g := range d.Groups {
+		g.Paths = slices.DeleteFunc(g.Paths, func(p string) bool { return Within(p, path) })
+		if len(g.Paths) > 1 {
+			groups = append(groups, g)
+		}
+	}
+	clear(d.Groups[len(groups):])
+	d.Groups = groups
+	d.summarize(limit)
+}
+
+// hashFiles hashes the first limit bytes of every file, all of it for a
+// negative limit, with at most Options.Threads files open at once like the
+// walk.  Files that cannot be read, or are not reached before ctx is
+// cancelled, are left without a hash.
+func (s *Scanner) hashFiles(ctx context.Context, opener Opener, groups [][]*dupFile, limit int64, d *Duplicates) {
+	sema := semaphore.NewWeighted(int64(max(s.opts.Threads, 1)))
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	for _, files := range groups {
+		for _, f := range files {
+			f.hash = ""
+			if sema.Acquire(ctx, 1) != nil {
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer sema.Release(1)
+				hash, err := hashFile(opener, f.path, limit)
+				mtx.Lock()
+				defer mtx.Unlock()
+				if !f.read {
+					f.read = true
+					d.Hashed++
+				}
+				if err != nil {
+					d.ReadErrors++
+					s.debugf("... duplicate check cannot read %s: %v\n", f.path, err)
+					return
+				}
+				f.hash = hash
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// splitByHash breaks each group up by hash and keeps the parts that still
+// have more than one file
+func splitByHash(groups [][]*dupFile) [][]*dupFile {
+	var out [][]*dupFile
+	for _, files := range groups {
+		parts := map[string][]*dupFile{}
+		for _, f := range files {
+			if f.hash != "" {
+				parts[f.hash] = append(parts[f.hash], f)
+			}
+		}
+		for _, part := range parts {
+			if len(part) > 1 {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// uniqueInodes keeps one path of every inode - the first by name so the
+// choice does not depend on the walk order
+func uniqueInodes(files []*dupFile) []*dupFile {
+	slices.SortFunc(files, func(a, b *dupFile) int { return strings.Compare(a.path, b.path) })
+	seen := map[fileId]bool{}
+	return slices.DeleteFunc(files, func(f *dupFile) bool {
+		if f.id.ino == 0 {
+			// no inode numbers on this platform so every file is its own
+			return false
+		}
+		if seen[f.id] {
+			return true
+		}
+		seen[f.id] = true
+		return false
+	})
+}
+
+// hashFile is the sha256 of the first limit bytes of a file, all of it for a
+// negative limit
+func hashFile(opener Opener, path string, limit int64) (string, error) {
+	f, err := opener.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
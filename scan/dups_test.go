@@ -0,0 +1,84 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 3*dupPartialSize)
+	// same size and same first block, different after it
+	bigOther := slices.Clone(big)
+	bigOther[len(bigOther)-1] = 'y'
+
+	fsys := NewMemFS()
+	fsys.Add("/r/a/big1", MemFile{Size: int64(len(big)), Data: big})
+	fsys.Add("/r/b/big2", MemFile{Size: int64(len(big)), Data: big})
+	fsys.Add("/r/b/big3", MemFile{Size: int64(len(big)), Data: big})
+	fsys.Add("/r/c/bigother", MemFile{Size: int64(len(big)), Data: bigOther})
+	fsys.Add("/r/a/small1", MemFile{Size: 5, Data: []byte("hello")})
+	fsys.Add("/r/c/small2", MemFile{Size: 5, Data: []byte("hello")})
+	fsys.Add("/r/c/small3", MemFile{Size: 5, Data: []byte("world")})
+	// hard links of one file are not copies of each other
+	fsys.Add("/r/a/link1", MemFile{Size: 77, Data: []byte("l"), Ino: 42})
+	fsys.Add("/r/b/link2", MemFile{Size: 77, Data: []byte("l"), Ino: 42})
+	fsys.Add("/r/a/empty1", MemFile{})
+	fsys.Add("/r/a/empty2", MemFile{})
+	fsys.Add("/r/a/unique", MemFile{Size: 9})
+
+	res, err := New(Options{FS: fsys, Threads: 3, TopN: 5, Duplicates: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := res.Duplicates
+	if d == nil || len(d.Groups) != 2 {
+		t.Fatalf("duplicates %+v", d)
+	}
+	if g := d.Groups[0]; g.Size != int64(len(big)) || !slices.Equal(g.Paths, []string{"/r/a/big1", "/r/b/big2", "/r/b/big3"}) || g.Reclaimable() != 2*g.Size {
+		t.Errorf("big group %+v", g)
+	}
+	if g := d.Groups[1]; !slices.Equal(g.Paths, []string{"/r/a/small1", "/r/c/small2"}) || len(g.Hash) != 64 {
+		t.Errorf("small group %+v", g)
+	}
+	if d.Reclaimable != 2*int64(len(big))+5 {
+		t.Errorf("reclaimable %d", d.Reclaimable)
+	}
+	if got := paths(d.DirsByReclaimable); len(got) != 2 || got[0] != "/r/b=24576" || got[1] != "/r/c=5" {
+		t.Errorf("dirs %v", got)
+	}
+	// 4 big and 3 small, the big ones read again in full are not counted twice
+	if d.Hashed != 7 || d.ReadErrors != 0 {
+		t.Errorf("hashed %d errors %d", d.Hashed, d.ReadErrors)
+	}
+}
+
+func TestDuplicatesMinSizeAndErrors(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a", MemFile{Size: 10})
+	fsys.Add("/r/b", MemFile{Size: 10})
+	fsys.Add("/r/c", MemFile{Size: 10, OpenErr: errors.New("denied")})
+	fsys.Add("/r/s1", MemFile{Size: 2})
+	fsys.Add("/r/s2", MemFile{Size: 2})
+
+	res, err := New(Options{FS: fsys, TopN: 5, Duplicates: true, DupMinSize: 3}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := res.Duplicates
+	if len(d.Groups) != 1 || !slices.Equal(d.Groups[0].Paths, []string{"/r/a", "/r/b"}) || d.ReadErrors != 1 {
+		t.Errorf("duplicates %+v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = New(Options{FS: fsys, TopN: 5, Duplicates: true}).Scan(ctx, "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Duplicates == nil || len(res.Duplicates.Groups) != 0 {
+		t.Errorf("duplicates of a cancelled scan %+v", res.Duplicates)
+	}
+}
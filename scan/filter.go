@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pattern is either a glob or, with a "re:" prefix, a regular expression.
+// Globs without a path separator match the entry name only, otherwise the
+// full path.  Regular expressions always match against the full path.
+type Pattern struct {
+	text string
+	glob string
+	full bool
+	re   *regexp.Regexp
+}
+
+func NewPattern(text string) (Pattern, error) {
+	if expr, ok := strings.CutPrefix(text, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return Pattern{}, err
+		}
+		return Pattern{text: text, re: re, full: true}, nil
+	}
+	if _, err := filepath.Match(text, ""); err != nil {
+		return Pattern{}, fmt.Errorf("bad glob %q: %w", text, err)
+	}
+	return Pattern{text: text, glob: text, full: strings.ContainsRune(text, filepath.Separator)}, nil
+}
+
+func (p *Pattern) Match(name string, path string) bool {
+	if p.re != nil {
+		return p.re.MatchString(path)
+	}
+	target := name
+	if p.full {
+		target = path
+	}
+	ok, _ := filepath.Match(p.glob, target)
+	return ok
+}
+
+// PatternList is a list of path patterns - it is a flag.Value so it can be a
+// repeatable flag
+type PatternList []Pattern
+
+func (l *PatternList) String() string {
+	var list []string
+	for _, p := range *l {
+		list = append(list, p.text)
+	}
+	return strings.Join(list, ",")
+}
+
+func (l *PatternList) Set(text string) error {
+	p, err := NewPattern(text)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+func (l PatternList) Matches(name string, path string) bool {
+	for i := range l {
+		if l[i].Match(name, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFile adds one pattern per line - blank lines and # comments are ignored
+func (l *PatternList) LoadFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := l.Set(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", fname, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// excludes apply to files and directories, includes only to files so
+// directories are still descended to find matching files
+func (s *Scanner) isExcluded(file fs.DirEntry, path string) bool {
+	if s.opts.Exclude.Matches(file.Name(), path) {
+		return true
+	}
+	if !file.IsDir() && len(s.opts.Include) > 0 && !s.opts.Include.Matches(file.Name(), path) {
+		return true
+	}
+	return false
+}
+
+// countExcluded keeps the excluded statistic - excluded directories are never
+// read so only the bytes of excluded files are known
+func (s *Scanner) countExcluded(file fs.DirEntry) {
+	if file.IsDir() {
+		s.excludedDirs.Add(1)
+		return
+	}
+	s.excludedFiles.Add(1)
+	if file.Type().IsRegular() {
+		if stats, err := file.Info(); err == nil {
+			s.excludedBytes.Add(uint64(stats.Size()))
+		}
+	}
+}
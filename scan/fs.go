@@ -0,0 +1,65 @@
+package scan
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FS is what a Scanner reads the tree through - the local disk by default.
+// Paths are absolute and built with filepath.Join.  All methods are called
+// from many walker goroutines at once.
+type FS interface {
+	ReadDir(path string) ([]fs.DirEntry, error)
+	Stat(path string) (fs.FileInfo, error)
+	// Sys is the owner, allocation and identity of a FileInfo returned by
+	// this FS, from Stat or from a DirEntry of ReadDir
+	Sys(info fs.FileInfo) FileSys
+}
+
+// FileSys is the part of a stat that fs.FileInfo does not cover
+type FileSys struct {
+	Uid uint32
+	Gid uint32
+	// Allocated is the bytes allocated on disk
+	Allocated int64
+	// Dev and Ino identify the file across hard links and Nlink counts them
+	Dev   uint64
+	Ino   uint64
+	Nlink uint64
+	// Atime and Ctime are the access and status change times in unix seconds
+	Atime int64
+	Ctime int64
+}
+
+// BirthTimer is an FS that knows when files were created - needed to scan
+// with TimeBirth
+type BirthTimer interface {
+	BirthTime(path string) (time.Time, error)
+}
+
+// OSFS is the local disk
+type OSFS struct{}
+
+func (OSFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func (OSFS) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (OSFS) Sys(info fs.FileInfo) FileSys {
+	id, nlink := getFileId(info)
+	atime, ctime := fileTimes(info)
+	return FileSys{
+		Uid:       FileOwner(info),
+		Gid:       FileGroup(info),
+		Allocated: FileAllocated(info),
+		Dev:       id.dev,
+		Ino:       id.ino,
+		Nlink:     nlink,
+		Atime:     atime,
+		Ctime:     ctime,
+	}
+}
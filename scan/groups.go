@@ -0,0 +1,82 @@
+package scan
+
+import (
+	"cmp"
+	"slices"
+)
+
+// GroupStats is what one group id owns like UserStats
+type GroupStats struct {
+	Gid    uint32
+	Size   uint64
+	Blocks uint64
+	Files  uint64
+	Dirs   uint64
+}
+
+// Usage is the apparent or allocated bytes
+func (group *GroupStats) Usage(allocated bool) uint64 {
+	if allocated {
+		return group.Blocks
+	}
+	return group.Size
+}
+
+// SortGroups orders like SortUsers
+func SortGroups(list []GroupStats, allocated bool) {
+	slices.SortFunc(list, func(i, j GroupStats) int {
+		if c := cmp.Compare(j.Usage(allocated), i.Usage(allocated)); c != 0 {
+			return c
+		}
+		return cmp.Compare(j.Files+j.Dirs, i.Files+i.Dirs)
+	})
+}
+
+// groupTally accumulates one group at a time like userTally - NullUserId is
+// also no group yet
+type groupTally struct {
+	GroupStats
+	s *Scanner
+}
+
+func (group *groupTally) switchGroup(gid uint32) {
+	group.flush()
+	group.GroupStats = GroupStats{Gid: gid}
+}
+
+func (group *groupTally) addDir(gid uint32) {
+	if group.Gid == NullUserId {
+		group.Gid = gid
+	}
+	if gid != group.Gid {
+		group.switchGroup(gid)
+	}
+	group.Dirs += 1
+}
+
+func (group *groupTally) addFile(gid uint32, size uint64, blocks uint64) {
+	if group.Gid == NullUserId {
+		group.Gid = gid
+	}
+	if gid != group.Gid {
+		group.switchGroup(gid)
+	}
+	group.Files += 1
+	group.Size += size
+	group.Blocks += blocks
+}
+
+func (group *groupTally) flush() {
+	if group.Gid == NullUserId {
+		return
+	}
+	info := group.GroupStats
+	group.s.groups.Compute(info.Gid, func(oldValue GroupStats, loaded bool) (newValue GroupStats, delete bool) {
+		oldValue.Gid = info.Gid
+		oldValue.Dirs += info.Dirs
+		oldValue.Files += info.Files
+		oldValue.Size += info.Size
+		oldValue.Blocks += info.Blocks
+		return oldValue, false
+	})
+}
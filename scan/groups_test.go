@@ -0,0 +1,39 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestGroupStats(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/p", MemFile{Mode: fs.ModeDir, Uid: 1, Gid: 200})
+	fsys.Add("/r/p/a", MemFile{Size: 5, Allocated: 4096, Uid: 1, Gid: 200})
+	// one user writing into two project groups
+	fsys.Add("/r/p/b", MemFile{Size: 70, Allocated: 4096, Uid: 1, Gid: 100})
+	fsys.Add("/r/q", MemFile{Size: 30, Allocated: 4096, Uid: 2, Gid: 200})
+	fsys.Add("/r/h1", MemFile{Size: 1, Uid: 2, Gid: 100, Ino: 9})
+	fsys.Add("/r/p/h2", MemFile{Size: 1, Uid: 2, Gid: 100, Ino: 9})
+
+	res, err := New(Options{FS: fsys, TopN: 5, DedupHardLinks: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GroupStats{
+		{Gid: 100, Size: 71, Blocks: 4096, Files: 3},
+		{Gid: 200, Size: 35, Blocks: 8192, Files: 2, Dirs: 1},
+	}
+	if got := res.GroupList(); !slices.Equal(got, want) {
+		t.Errorf("groups got %+v want %+v", got, want)
+	}
+	if len(res.Users) != 2 {
+		t.Errorf("users %+v", res.Users)
+	}
+
+	res.Options.Allocated = true
+	if got := res.GroupList(); got[0].Gid != 200 {
+		t.Errorf("allocated order %+v", got)
+	}
+}
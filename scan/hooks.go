@@ -0,0 +1,44 @@
+package scan
+
+import "io/fs"
+
+// Hooks are optional callbacks from inside the walk.  Every path is absolute
+// and depth is the number of directories between it and the root - the root
+// is 0 and the entries directly in it are 1.
+//
+// Hooks are called from the walker goroutines, so different directories call
+// them concurrently and they must be safe for that.  What is guaranteed:
+//
+//   - OnDir for a directory comes before any hook call for anything in it
+//   - the entries of one directory are handed over by one goroutine, in the
+//     name order of the listing
+//   - OnDirDone for a directory comes after every hook call for anything
+//     below it, so it also comes after OnDirDone of all its subdirectories
+//
+// There is no ordering between sibling directories.  A hook that blocks
+// holds up its walker goroutine and so the scan.
+type Hooks struct {
+	// OnDir is called for every directory below the root about to be read,
+	// and with Options.Archives for every readable archive before it is
+	// walked as one.  Returning false prunes it - the directory and everything
+	// under it is left out of the tree and every count as if it did not
+	// exist.  A pruned archive is counted as the plain file it is instead.
+	OnDir func(path string, entry fs.DirEntry, depth int) bool
+	// OnFile is called for every regular file counted, including hard links
+	// to an inode already seen with Options.DedupHardLinks and archives that
+	// are not walked as directories
+	OnFile func(path string, info fs.FileInfo, depth int)
+	// OnDirDone is called once the directory and all of its subtree is
+	// walked.  The Rec totals of dir are complete at that point.  Setting it
+	// rolls totals up during the walk instead of after, like Options.Stream.
+	OnDirDone func(dir *DirInfo, depth int)
+	// OnError is called for every directory that could not be read and every
+	// file or directory that could not be stat'ed
+	OnError func(path string, err error, depth int)
+}
+
+func (s *Scanner) onError(path string, err error, depth int) {
+	if s.opts.Hooks.OnError != nil {
+		s.opts.Hooks.OnError(path, err, depth)
+	}
+}
@@ -0,0 +1,254 @@
+package scan
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// OtherCategory is the category of files no rule matches
+const OtherCategory = "other"
+
+// DefaultCategories are used when no category file is given - one category
+// per line as "name: pattern pattern ..." like ParseCategories reads
+const DefaultCategories = `video: .mp4 .mkv .avi .mov .wmv .flv .webm .m4v .mpg .mpeg
+audio: .mp3 .flac .wav .ogg .m4a .aac
+images: .jpg .jpeg .png .gif .bmp .tif .tiff .svg .webp .heic
+archives: .zip .tar .tar.gz .tgz .tar.zst .tzst .tar.bz2 .tar.xz .gz .bz2 .xz .zst .7z .rar
+logs: .log .out .err .trace
+source: .go .c .h .cc .cpp .hpp .java .py .rs .js .ts .tsx .jsx .rb .sh .pl .scala .kt .swift .cs .php .sql
+build outputs: .o .a .so .class .jar .war .pyc .obj .lib .dll .exe .whl .rlib .dylib
+data: .parquet .csv .json .avro .orc .db .sqlite .h5
+documents: .pdf .doc .docx .xls .xlsx .ppt .pptx .txt .md
+core dumps: core core.*
+`
+
+// NewDefaultCategories are the DefaultCategories
+func NewDefaultCategories() *Categories {
+	c, err := ParseCategories(strings.NewReader(DefaultCategories))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Categories map files to a kind like video or logs - by extension first and
+// then by patterns on the name in the order they were given
+type Categories struct {
+	byExt  map[string]string
+	byName []namedPattern
+}
+
+type namedPattern struct {
+	pattern  Pattern
+	category string
+}
+
+// ParseCategories reads one category per line as "name: pattern ..." where a
+// pattern starting with a dot is an extension and anything else a Pattern on
+// the file name.  Blank lines and # comments are ignored.
+func ParseCategories(r io.Reader) (*Categories, error) {
+	c := &Categories{byExt: map[string]string{}}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, patterns, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected \"category: patterns\"", lineNo)
+		}
+		for _, p := range strings.Fields(patterns) {
+			if strings.HasPrefix(p, ".") {
+				c.byExt[strings.ToLower(p)] = name
+				continue
+			}
+			pattern, err := NewPattern(p)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			c.byName = append(c.byName, namedPattern{pattern, name})
+		}
+	}
+	return c, scanner.Err()
+}
+
+// Category of a file with the extension from FileExt
+func (c *Categories) Category(name, path, ext string) string {
+	if category, ok := c.byExt[ext]; ok {
+		return category
+	}
+	for i := range c.byName {
+		if c.byName[i].pattern.Match(name, path) {
+			return c.byName[i].category
+		}
+	}
+	return OtherCategory
+}
+
+// FileExt is the lowercase extension with its dot, "" for none.  Numbered
+// rotations like app.log.1 count as their base extension, .tar.gz and the
+// like as one, and a dot file like .bashrc has no extension.
+func FileExt(name string) string {
+	name = strings.ToLower(name)
+	ext := filepath.Ext(name)
+	if len(ext) > 1 && strings.Trim(ext[1:], "0123456789") == "" {
+		name = name[:len(name)-len(ext)]
+		ext = filepath.Ext(name)
+	}
+	if len(ext) == len(name) {
+		return ""
+	}
+	switch ext {
+	case ".gz", ".zst", ".bz2", ".xz":
+		if base := name[:len(name)-len(ext)]; filepath.Ext(base) == ".tar" && len(base) > len(".tar") {
+			return ".tar" + ext
+		}
+	}
+	return ext
+}
+
+// KindStats is what the files of one extension and category add up to
+type KindStats struct {
+	Ext      string
+	Category string
+	Size     uint64
+	Blocks   uint64
+	Files    uint64
+}
+
+// Usage is the apparent or allocated bytes
+func (k *KindStats) Usage(allocated bool) uint64 {
+	if allocated {
+		return k.Blocks
+	}
+	return k.Size
+}
+
+func (k *KindStats) add(o KindStats) {
+	k.Size += o.Size
+	k.Blocks += o.Blocks
+	k.Files += o.Files
+}
+
+type kindKey struct {
+	ext      string
+	category string
+}
+
+// GroupKinds sums a list by extension or by category, largest first.  By
+// category the Ext of every entry is "" and by extension Category is the
+// one with the most bytes when rules gave the same extension more than one.
+func GroupKinds(list []KindStats, byCategory bool, allocated bool) []KindStats {
+	groups := map[string]*KindStats{}
+	best := map[string]uint64{}
+	for _, k := range list {
+		key := k.Ext
+		if byCategory {
+			key = k.Category
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &KindStats{Ext: k.Ext, Category: k.Category}
+			if byCategory {
+				g.Ext = ""
+			}
+			groups[key] = g
+		}
+		if !byCategory && k.Usage(allocated) > best[key] {
+			best[key] = k.Usage(allocated)
+			g.Category = k.Category
+		}
+		g.add(k)
+	}
+	out := make([]KindStats, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, *g)
+	}
+	sortKinds(out, allocated)
+	return out
+}
+
+// sortKinds is by usage, then file count and then name so ties are stable
+func sortKinds(list []KindStats, allocated bool) {
+	slices.SortFunc(list, func(a, b KindStats) int {
+		if c := cmp.Compare(b.Usage(allocated), a.Usage(allocated)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Files, a.Files); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Category, b.Category); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Ext, b.Ext)
+	})
+}
+
+// kindTally accumulates the files of one directory so the shared map is only
+// touched once when the directory is done
+type kindTally struct {
+	kinds map[kindKey]KindStats
+	s     *Scanner
+	top   string
+}
+
+func (t *kindTally) addFile(name, path string, size, blocks uint64) {
+	ext := FileExt(name)
+	key := kindKey{ext, t.s.opts.Categories.Category(name, path, ext)}
+	k := t.kinds[key]
+	k.add(KindStats{Size: size, Blocks: blocks, Files: 1})
+	t.kinds[key] = k
+}
+
+func (t *kindTally) flush() {
+	for key, k := range t.kinds {
+		t.s.kinds.Compute(topKindKey{t.top, key}, func(old KindStats, loaded bool) (KindStats, bool) {
+			old.add(k)
+			return old, false
+		})
+	}
+}
+
+type topKindKey struct {
+	top string
+	kindKey
+}
+
+// topDir is the directory right below the root that dir is in - the root
+// itself for the root
+func topDir(dir *DirInfo, depth int) string {
+	for ; depth > 1; depth-- {
+		dir = dir.Parent
+	}
+	return dir.Name
+}
+
+// kindResults turns the per top directory tallies into the Result lists
+func (s *Scanner) kindResults(res *Result) {
+	all := map[kindKey]*KindStats{}
+	res.KindsByTopDir = map[string][]KindStats{}
+	s.kinds.Range(func(key topKindKey, k KindStats) bool {
+		k.Ext, k.Category = key.ext, key.category
+		res.KindsByTopDir[key.top] = append(res.KindsByTopDir[key.top], k)
+		if sum, ok := all[key.kindKey]; ok {
+			sum.add(k)
+		} else {
+			all[key.kindKey] = &k
+		}
+		return true
+	})
+	for _, k := range all {
+		res.Kinds = append(res.Kinds, *k)
+	}
+	sortKinds(res.Kinds, s.opts.Allocated)
+	for _, list := range res.KindsByTopDir {
+		sortKinds(list, s.opts.Allocated)
+	}
+}
@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFileExt(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.LOG": ".log", "app.log.1": ".log", "app.log.12.gz": ".gz", "x.tar.gz": ".tar.gz",
+		"tar.gz": ".gz", ".bashrc": "", ".bashrc.1": "", "core": "", "core.4242": "", "Makefile": "",
+		"data.parquet": ".parquet", "a.b.c": ".c",
+	} {
+		if got := FileExt(name); got != want {
+			t.Errorf("%s: got %q want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	c, err := ParseCategories(strings.NewReader("# comment\n\nlogs: .log .OUT\ndumps: core core.* re:/crash/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct{ name, path, want string }{
+		{"a.log", "/x/a.log", "logs"},
+		{"a.out", "/x/a.out", "logs"},
+		{"core.12", "/x/core.12", "dumps"},
+		{"dump.bin", "/x/crash/dump.bin", "dumps"},
+		{"a.go", "/x/a.go", OtherCategory},
+	} {
+		if got := c.Category(f.name, f.path, FileExt(f.name)); got != f.want {
+			t.Errorf("%s: got %q want %q", f.path, got, f.want)
+		}
+	}
+	if _, err := ParseCategories(strings.NewReader("no colon here\n")); err == nil {
+		t.Error("no error for a line without a category")
+	}
+	if _, err := ParseCategories(strings.NewReader("bad: [\n")); err == nil {
+		t.Error("no error for a bad glob")
+	}
+	NewDefaultCategories()
+}
+
+func TestScanKinds(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/top.log", MemFile{Size: 1, Allocated: 4096})
+	fsys.Add("/r/a/x.log", MemFile{Size: 10, Allocated: 4096})
+	fsys.Add("/r/a/deep/y.LOG.3", MemFile{Size: 20, Allocated: 4096})
+	fsys.Add("/r/a/deep/movie.mkv", MemFile{Size: 5000, Allocated: 8192})
+	fsys.Add("/r/b/core.99", MemFile{Size: 700, Allocated: 4096})
+	fsys.Add("/r/b/README", MemFile{Size: 3})
+
+	res, err := New(Options{FS: fsys, Threads: 2, Categories: NewDefaultCategories()}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KindStats{
+		{".mkv", "video", 5000, 8192, 1},
+		{"", "core dumps", 700, 4096, 1},
+		{".log", "logs", 31, 12288, 3},
+		{"", OtherCategory, 3, 0, 1},
+	}
+	if len(res.Kinds) != len(want) {
+		t.Fatalf("kinds %+v", res.Kinds)
+	}
+	for i := range want {
+		if res.Kinds[i] != want[i] {
+			t.Errorf("kind %d got %+v want %+v", i, res.Kinds[i], want[i])
+		}
+	}
+	if len(res.KindsByTopDir) != 3 {
+		t.Errorf("top dirs %v", res.KindsByTopDir)
+	}
+	if a := res.KindsByTopDir["/r/a"]; len(a) != 2 || a[1] != (KindStats{".log", "logs", 30, 8192, 2}) {
+		t.Errorf("kinds of /r/a %+v", a)
+	}
+	if r := res.KindsByTopDir["/r"]; len(r) != 1 || r[0].Files != 1 {
+		t.Errorf("kinds of the root itself %+v", r)
+	}
+
+	byCategory := GroupKinds(res.Kinds, true, true)
+	if byCategory[0].Category != "logs" || byCategory[0].Ext != "" || byCategory[0].Blocks != 12288 {
+		t.Errorf("by category allocated %+v", byCategory)
+	}
+	byExt := GroupKinds(res.Kinds, false, false)
+	if len(byExt) != 3 || byExt[2].Ext != ".log" || byExt[1].Ext != "" || byExt[1].Category != "core dumps" || byExt[1].Files != 2 {
+		t.Errorf("by extension %+v", byExt)
+	}
+
+	res, err = New(Options{FS: fsys}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Kinds != nil || res.KindsByTopDir != nil {
+		t.Error("kinds without Categories")
+	}
+}
@@ -0,0 +1,198 @@
+package scan
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// MemFile is one entry of a MemFS.  The zero value is an empty regular file
+// owned by uid 0 and gid 0.
+type MemFile struct {
+	// Mode only needs the type bits - fs.ModeDir for a directory
+	Mode      fs.FileMode
+	Size      int64
+	Allocated int64
+	ModTime   time.Time
+	// the other times are the ModTime when not set
+	Atime time.Time
+	Ctime time.Time
+	Btime time.Time
+	Uid   uint32
+	Gid   uint32
+	// entries with the same Ino are hard links of each other - 0 is a new inode
+	Ino uint64
+	Dev uint64
+	// ReadErr fails the ReadDir of a directory and StatErr the stat of the entry
+	ReadErr error
+	StatErr error
+	// Data is the start of the content of a file - the rest up to Size is zeros.
+	// OpenErr fails opening it.
+	Data    []byte
+	OpenErr error
+}
+
+// MemFS is an in memory FS so scans can be tested without touching a disk.
+// Add everything before scanning - it is not safe to change during a scan.
+type MemFS struct {
+	entries  map[string]*MemFile
+	children map[string][]string
+	links    map[uint64]uint64
+	nextIno  uint64
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		entries:  map[string]*MemFile{},
+		children: map[string][]string{},
+		links:    map[uint64]uint64{},
+		// well away from any Ino given to Add
+		nextIno: 1 << 40,
+	}
+}
+
+// Add puts f at path, replacing what was there, and creates any missing
+// parent directories
+func (m *MemFS) Add(path string, f MemFile) {
+	path = filepath.Clean(path)
+	if f.Ino == 0 {
+		m.nextIno++
+		f.Ino = m.nextIno
+	}
+	if old, ok := m.entries[path]; ok {
+		m.links[old.Ino]--
+	}
+	m.links[f.Ino]++
+	m.entries[path] = &f
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return
+	}
+	if _, ok := m.entries[parent]; !ok {
+		m.Add(parent, MemFile{Mode: fs.ModeDir})
+	}
+	name := filepath.Base(path)
+	if i, found := slices.BinarySearch(m.children[parent], name); !found {
+		m.children[parent] = slices.Insert(m.children[parent], i, name)
+	}
+}
+
+func (m *MemFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	path = filepath.Clean(path)
+	f, ok := m.entries[path]
+	switch {
+	case !ok:
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	case !f.Mode.IsDir():
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrInvalid}
+	case f.ReadErr != nil:
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: f.ReadErr}
+	}
+	list := make([]fs.DirEntry, 0, len(m.children[path]))
+	for _, name := range m.children[path] {
+		list = append(list, memEntry{memInfo{name, m.entries[filepath.Join(path, name)]}})
+	}
+	return list, nil
+}
+
+func (m *MemFS) Stat(path string) (fs.FileInfo, error) {
+	path = filepath.Clean(path)
+	f, ok := m.entries[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	if f.StatErr != nil {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: f.StatErr}
+	}
+	return memInfo{filepath.Base(path), f}, nil
+}
+
+func (m *MemFS) Sys(info fs.FileInfo) FileSys {
+	f := info.Sys().(*MemFile)
+	return FileSys{
+		Uid:       f.Uid,
+		Gid:       f.Gid,
+		Allocated: f.Allocated,
+		Dev:       f.Dev,
+		Ino:       f.Ino,
+		Nlink:     m.links[f.Ino],
+		Atime:     orModTime(f.Atime, f).Unix(),
+		Ctime:     orModTime(f.Ctime, f).Unix(),
+	}
+}
+
+func (m *MemFS) BirthTime(path string) (time.Time, error) {
+	info, err := m.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	f := info.Sys().(*MemFile)
+	return orModTime(f.Btime, f), nil
+}
+
+func (m *MemFS) Open(path string) (io.ReadCloser, error) {
+	info, err := m.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f := info.Sys().(*MemFile)
+	if f.OpenErr != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: f.OpenErr}
+	}
+	if f.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	zeros := io.LimitReader(zeroReader{}, max(f.Size-int64(len(f.Data)), 0))
+	return io.NopCloser(io.MultiReader(bytes.NewReader(f.Data), zeros)), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func orModTime(t time.Time, f *MemFile) time.Time {
+	if t.IsZero() {
+		return f.ModTime
+	}
+	return t
+}
+
+type memInfo struct {
+	name string
+	f    *MemFile
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.f.Size }
+func (i memInfo) ModTime() time.Time { return i.f.ModTime }
+func (i memInfo) IsDir() bool        { return i.f.Mode.IsDir() }
+func (i memInfo) Sys() any           { return i.f }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.f.Mode.IsDir() {
+		return i.f.Mode | 0755
+	}
+	return i.f.Mode | 0644
+}
+
+type memEntry struct {
+	info memInfo
+}
+
+func (e memEntry) Name() string      { return e.info.name }
+func (e memEntry) IsDir() bool       { return e.info.IsDir() }
+func (e memEntry) Type() fs.FileMode { return e.info.f.Mode.Type() }
+
+func (e memEntry) Info() (fs.FileInfo, error) {
+	if e.info.f.StatErr != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: e.info.name, Err: e.info.f.StatErr}
+	}
+	return e.info, nil
+}
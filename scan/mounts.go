@@ -0,0 +1,154 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultExcludeFsTypes are the filesystem types not worth descending into
+const DefaultExcludeFsTypes = "proc,sysfs,cgroup,cgroup2,devtmpfs,devpts,mqueue,debugfs,tracefs,securityfs,pstore,bpf,configfs,fusectl,binfmt_misc,autofs,nfs,nfs4,fuse.sshfs,overlay"
+
+// MountInfo is one line of /proc/self/mountinfo plus what the scan found on it
+type MountInfo struct {
+	Id         int
+	Parent     int
+	Dev        string
+	MountPoint string
+	FsType     string
+	Source     string
+	// accumulated during the scan
+	Size  uint64
+	Files uint64
+	Dirs  uint64
+	// filled in from statfs when the scan is done
+	Capacity uint64
+	Free     uint64
+}
+
+func (m *MountInfo) add(size uint64, files uint64, dirs uint64) {
+	atomic.AddUint64(&m.Size, size)
+	atomic.AddUint64(&m.Files, files)
+	atomic.AddUint64(&m.Dirs, dirs)
+}
+
+// unescapeMountField undoes the octal escaping of space, tab, newline and backslash
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseMountInfo reads the /proc/<pid>/mountinfo format.  Later mounts on the
+// same mount point hide earlier ones so they replace them in the map.
+func ParseMountInfo(r io.Reader) (map[string]*MountInfo, error) {
+	mounts := make(map[string]*MountInfo)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if sep < 6 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", scanner.Text())
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad mount id in line: %q", scanner.Text())
+		}
+		parent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad parent mount id in line: %q", scanner.Text())
+		}
+		m := &MountInfo{
+			Id:         id,
+			Parent:     parent,
+			Dev:        fields[2],
+			MountPoint: unescapeMountField(fields[4]),
+			FsType:     fields[sep+1],
+			Source:     unescapeMountField(fields[sep+2]),
+		}
+		mounts[m.MountPoint] = m
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// cloneMounts gives each scan its own counters
+func cloneMounts(mounts map[string]*MountInfo) map[string]*MountInfo {
+	if mounts == nil {
+		return nil
+	}
+	clone := make(map[string]*MountInfo, len(mounts))
+	for p, m := range mounts {
+		c := *m
+		c.Size, c.Files, c.Dirs, c.Capacity, c.Free = 0, 0, 0, 0, 0
+		clone[p] = &c
+	}
+	return clone
+}
+
+// mountFor finds the mount holding path by the longest mount point prefix
+func (s *Scanner) mountFor(path string) *MountInfo {
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if m, ok := s.mounts[p]; ok {
+			return m
+		}
+		if p == filepath.Dir(p) {
+			return nil
+		}
+	}
+}
+
+// mountExcluded applies the include list first since it is the stricter option
+func (s *Scanner) mountExcluded(m *MountInfo) bool {
+	if len(s.opts.IncludeFsTypes) > 0 {
+		return !s.opts.IncludeFsTypes[m.FsType]
+	}
+	return s.opts.ExcludeFsTypes[m.FsType]
+}
+
+func (s *Scanner) addSkippedMount(path string) {
+	s.skippedMounts.Add(1)
+	s.listMtx.Lock()
+	defer s.listMtx.Unlock()
+	s.skippedMountPaths = append(s.skippedMountPaths, path)
+}
+
+func (s *Scanner) addExcludedMount(m *MountInfo) {
+	s.filterDirs.Add(1)
+	s.listMtx.Lock()
+	defer s.listMtx.Unlock()
+	s.excludedMountPaths = append(s.excludedMountPaths, m.MountPoint+" ("+m.FsType+")")
+}
+
+// touchedMounts are the mounts the scan actually counted something on
+func (s *Scanner) touchedMounts() []*MountInfo {
+	var list []*MountInfo
+	for _, m := range s.mounts {
+		if m.Files > 0 || m.Dirs > 0 {
+			list = append(list, m)
+		}
+	}
+	slices.SortFunc(list, func(a, b *MountInfo) int {
+		return strings.Compare(a.MountPoint, b.MountPoint)
+	})
+	return list
+}
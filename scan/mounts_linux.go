@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package scan
+
+import (
+	"os"
+	"syscall"
+)
+
+// LoadMounts reads the mount table of this process keyed by mount point
+func LoadMounts() (map[string]*MountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMountInfo(f)
+}
+
+// FsSpace is the capacity and free bytes of the filesystem holding path
+func FsSpace(path string) (capacity uint64, free uint64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize)
+}
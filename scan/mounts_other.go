@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package scan
+
+import (
+	"errors"
+)
+
+// LoadMounts reads the mount table of this process keyed by mount point
+func LoadMounts() (map[string]*MountInfo, error) {
+	return nil, errors.New("mount table only supported on linux")
+}
+
+// FsSpace is the capacity and free bytes of the filesystem holding path
+func FsSpace(path string) (capacity uint64, free uint64) {
+	return 0, 0
+}
@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnescapeMountField(t *testing.T) {
+	for in, want := range map[string]string{
+		"/mnt/plain":             "/mnt/plain",
+		`/mnt/my\040disk`:        "/mnt/my disk",
+		`/mnt/a\011b\012c`:       "/mnt/a\tb\nc",
+		`/mnt/back\134slash`:     `/mnt/back\slash`,
+		`/mnt/end\040`:           "/mnt/end ",
+		`/mnt/short\04`:          `/mnt/short\04`,
+		`/mnt/not\08x`:           `/mnt/not\08x`,
+		`\040\040`:               "  ",
+		`/mnt/\134040 not twice`: `/mnt/\040 not twice`,
+	} {
+		if got := unescapeMountField(in); got != want {
+			t.Errorf("%q: got %q want %q", in, got, want)
+		}
+	}
+}
+
+// lines as the kernel writes them - optional fields come before the "-"
+const testMountInfo = `22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw,errors=remount-ro
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 0:22 / /sys rw,nosuid,nodev,noexec,relatime shared:2 - sysfs sysfs rw
+60 22 259:3 / /home rw,relatime shared:30 master:2 - xfs /dev/nvme0n1p3 rw,attr2,inode64
+61 22 0:50 / /mnt/my\040disk rw,relatime - vfat /dev/sdb1 rw,fmask=0022
+62 22 0:51 /exports /mnt/nfs rw,relatime shared:40 - nfs4 server:/exports rw,vers=4.2
+63 60 0:52 / /home/user/remote\040files rw,nosuid,nodev,relatime shared:41 - fuse.sshfs user@host:/srv\040data rw,user_id=1000
+64 22 0:53 / /mnt/nfs rw,relatime - tmpfs tmpfs rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := ParseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 7 {
+		t.Errorf("%d mounts", len(mounts))
+	}
+	for _, want := range []MountInfo{
+		{Id: 22, Parent: 1, Dev: "259:2", MountPoint: "/", FsType: "ext4", Source: "/dev/nvme0n1p2"},
+		{Id: 23, Parent: 22, Dev: "0:21", MountPoint: "/proc", FsType: "proc", Source: "proc"},
+		{Id: 60, Parent: 22, Dev: "259:3", MountPoint: "/home", FsType: "xfs", Source: "/dev/nvme0n1p3"},
+		{Id: 61, Parent: 22, Dev: "0:50", MountPoint: "/mnt/my disk", FsType: "vfat", Source: "/dev/sdb1"},
+		{Id: 63, Parent: 60, Dev: "0:52", MountPoint: "/home/user/remote files", FsType: "fuse.sshfs", Source: "user@host:/srv data"},
+		// mounted over the nfs mount so it hides it
+		{Id: 64, Parent: 22, Dev: "0:53", MountPoint: "/mnt/nfs", FsType: "tmpfs", Source: "tmpfs"},
+	} {
+		got, ok := mounts[want.MountPoint]
+		if !ok {
+			t.Errorf("no mount at %q", want.MountPoint)
+			continue
+		}
+		if *got != want {
+			t.Errorf("%q: got %+v want %+v", want.MountPoint, *got, want)
+		}
+	}
+}
+
+func TestParseMountInfoMalformed(t *testing.T) {
+	for _, line := range []string{
+		"22 1 259:2 / / rw,relatime shared:1 ext4 /dev/nvme0n1p2 rw",
+		"22 1 259:2 / / rw,relatime - ext4",
+		"22 1 259:2 / - ext4 /dev/sda1 rw",
+		"x 1 259:2 / / rw - ext4 /dev/sda1 rw",
+		"22 y 259:2 / / rw - ext4 /dev/sda1 rw",
+	} {
+		if _, err := ParseMountInfo(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("accepted %q", line)
+		}
+	}
+}
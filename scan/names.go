@@ -0,0 +1,166 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names resolves user and group ids to names through os/user and caches
+// every answer.  Ids without a name are shown as their number.  A nil *Names
+// always gives numbers.
+type Names struct {
+	mtx    sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+	// set when loaded from a file so the local system is not asked - the
+	// ids of an NFS export from another host mean nothing here
+	usersFixed  bool
+	groupsFixed bool
+}
+
+func NewNames() *Names {
+	return &Names{users: map[uint32]string{}, groups: map[uint32]string{}}
+}
+
+// LoadPasswd reads users from a file in /etc/passwd format and stops any
+// lookups of users through os/user
+func (n *Names) LoadPasswd(r io.Reader) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.usersFixed = true
+	return readIdFile(r, n.users)
+}
+
+// LoadGroup reads groups from a file in /etc/group format and stops any
+// lookups of groups through os/user
+func (n *Names) LoadGroup(r io.Reader) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.groupsFixed = true
+	return readIdFile(r, n.groups)
+}
+
+// readIdFile takes name and id from the first and third fields of
+// colon separated lines like passwd and group files - the first name of an
+// id wins like it does for getpwuid
+func readIdFile(r io.Reader, names map[uint32]string) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" {
+			return fmt.Errorf("line %d: expected \"name:password:id:...\"", lineNo)
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: bad id: %w", lineNo, err)
+		}
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+// User is the name of uid
+func (n *Names) User(uid uint32) string {
+	return n.lookup(uid, false)
+}
+
+// Group is the name of gid
+func (n *Names) Group(gid uint32) string {
+	return n.lookup(gid, true)
+}
+
+// UserId is the uid of a user name, or of a number as is
+func (n *Names) UserId(name string) (uint32, error) {
+	return n.lookupId(name, false)
+}
+
+// GroupId is the gid of a group name, or of a number as is
+func (n *Names) GroupId(name string) (uint32, error) {
+	return n.lookupId(name, true)
+}
+
+func (n *Names) lookupId(name string, isGroup bool) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	kind := "user"
+	if isGroup {
+		kind = "group"
+	}
+	if n == nil {
+		return 0, fmt.Errorf("%s '%s' is not a number", kind, name)
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	names, fixed := n.users, n.usersFixed
+	if isGroup {
+		names, fixed = n.groups, n.groupsFixed
+	}
+	if fixed {
+		for id, known := range names {
+			if known == name {
+				return id, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown %s '%s'", kind, name)
+	}
+	var idStr string
+	if isGroup {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, err
+		}
+		idStr = g.Gid
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		idStr = u.Uid
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s '%s' has id '%s' that is not a number", kind, name, idStr)
+	}
+	names[uint32(id)] = name
+	return uint32(id), nil
+}
+
+func (n *Names) lookup(id uint32, isGroup bool) string {
+	if n == nil || id == NullUserId {
+		return strconv.FormatUint(uint64(id), 10)
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	names, fixed := n.users, n.usersFixed
+	if isGroup {
+		names, fixed = n.groups, n.groupsFixed
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(id), 10)
+	if !fixed {
+		if isGroup {
+			if g, err := user.LookupGroupId(name); err == nil {
+				name = g.Name
+			}
+		} else if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+	}
+	// unknown ids are cached too so they are only looked up once
+	names[id] = name
+	return name
+}
@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamesFromFiles(t *testing.T) {
+	n := NewNames()
+	passwd := "# comment\nroot:x:0:0:root:/root:/bin/bash\nalice:x:1001:100::/home/alice:/bin/sh\ntoor:x:0:0::/:/bin/sh\n"
+	if err := n.LoadPasswd(strings.NewReader(passwd)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.LoadGroup(strings.NewReader("wheel:x:10:alice\nproj:x:2000:\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		got, want string
+	}{
+		{n.User(0), "root"}, {n.User(1001), "alice"}, {n.User(4242), "4242"},
+		{n.Group(2000), "proj"}, {n.Group(10), "wheel"}, {n.Group(0), "0"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q want %q", c.got, c.want)
+		}
+	}
+	if id, err := n.UserId("alice"); err != nil || id != 1001 {
+		t.Errorf("alice is %d %v", id, err)
+	}
+	if id, err := n.UserId("77"); err != nil || id != 77 {
+		t.Errorf("77 is %d %v", id, err)
+	}
+	if id, err := n.GroupId("proj"); err != nil || id != 2000 {
+		t.Errorf("proj is %d %v", id, err)
+	}
+	if _, err := n.UserId("mallory"); err == nil {
+		t.Error("no error for an unknown user")
+	}
+	if err := n.LoadPasswd(strings.NewReader("bad line\n")); err == nil {
+		t.Error("no error for a line without an id")
+	}
+	if err := n.LoadGroup(strings.NewReader("g:x:nope:\n")); err == nil {
+		t.Error("no error for a bad id")
+	}
+}
+
+func TestNilNames(t *testing.T) {
+	var n *Names
+	if n.User(12) != "12" || n.Group(34) != "34" {
+		t.Errorf("nil names %s %s", n.User(12), n.Group(34))
+	}
+	if _, err := n.UserId("root"); err == nil {
+		t.Error("nil names found a user by name")
+	}
+	if NewNames().User(NullUserId) != "4294967295" {
+		t.Error("the null user id was looked up")
+	}
+}
@@ -0,0 +1,280 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Removal is what one file or directory of a scanned tree held, measured with
+// Result.Measure before it is deleted so Result.Remove can take it back out
+type Removal struct {
+	Path  string
+	IsDir bool
+	// Totals count a directory itself in Dirs
+	Totals     Totals
+	Users      map[uint32]UserStats
+	Groups     map[uint32]GroupStats
+	Kinds      []KindStats
+	Ages       AgeHistogram
+	AgesByUser map[uint32]AgeHistogram
+	// Skipped is the entries below a directory that the scan did not count -
+	// other filesystems, excluded mounts and patterns, special and unreadable
+	// directories.  Deleting the directory as a whole would delete those too.
+	Skipped uint64
+}
+
+// Measure reads path, a directory of the tree or a file in one, again with
+// the options of the scan so it is filtered the same way - only the Hooks are
+// left out.  Ages are taken at Result.Start like the scan did.
+func (r *Result) Measure(ctx context.Context, path string) (*Removal, error) {
+	fsys := r.Options.FS
+	if fsys == nil {
+		fsys = OSFS{}
+	}
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return r.measureFile(fsys, path, info), nil
+	}
+
+	opts := r.Options
+	opts.Stream = nil
+	opts.Hooks = Hooks{}
+	opts.TopN = 0
+	opts.StaleAge = 0
+	opts.UserTops = false
+	opts.Duplicates = false
+	opts.Debug = nil
+	sub, err := New(opts).scan(ctx, path, r.Start)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Incomplete != nil {
+		return nil, sub.Incomplete
+	}
+	m := &Removal{
+		Path:       path,
+		IsDir:      true,
+		Totals:     sub.Totals,
+		Users:      sub.Users,
+		Groups:     sub.Groups,
+		Kinds:      sub.Kinds,
+		Ages:       sub.Ages,
+		AgesByUser: sub.AgesByUser,
+	}
+	// the scan counts what is below its root, the parent listing counted the
+	// directory itself
+	sys := fsys.Sys(info)
+	m.Totals.Dirs++
+	u := m.Users[sys.Uid]
+	u.Uid = sys.Uid
+	u.Dirs++
+	m.Users[sys.Uid] = u
+	g := m.Groups[sys.Gid]
+	g.Gid = sys.Gid
+	g.Dirs++
+	m.Groups[sys.Gid] = g
+
+	// excluded mounts are counted in FilteredDirs as well
+	errs := &sub.Errors
+	m.Skipped = errs.FilteredDirs + errs.DirList + errs.FileStat + uint64(len(errs.SkippedMounts)) +
+		sub.Totals.ExcludedFiles + sub.Totals.ExcludedDirs
+	return m, nil
+}
+
+func (r *Result) measureFile(fsys FS, path string, info fs.FileInfo) *Removal {
+	sys := fsys.Sys(info)
+	size, blocks := uint64(info.Size()), uint64(sys.Allocated)
+	m := &Removal{
+		Path:   path,
+		Totals: Totals{Size: size, Allocated: blocks, Files: 1},
+		Users:  map[uint32]UserStats{sys.Uid: {Uid: sys.Uid, Size: size, Blocks: blocks, Files: 1}},
+		Groups: map[uint32]GroupStats{sys.Gid: {Gid: sys.Gid, Size: size, Blocks: blocks, Files: 1}},
+	}
+	if c := r.Options.Categories; c != nil {
+		name := filepath.Base(path)
+		ext := FileExt(name)
+		m.Kinds = []KindStats{{Ext: ext, Category: c.Category(name, path, ext), Size: size, Blocks: blocks, Files: 1}}
+	}
+	if r.Options.AgeHistogram {
+		ftime := fileTime(r.Options.TimeField, fsys, path, info, sys)
+		m.Ages[ageBucket(time.Duration(r.Start.Unix()-ftime)*time.Second)] = AgeBucket{Size: size, Blocks: blocks, Files: 1}
+		m.AgesByUser = map[uint32]AgeHistogram{sys.Uid: m.Ages}
+	}
+	return m
+}
+
+// Remove takes a deleted file or directory out of the tree, the totals and
+// every per user, group, kind, age and duplicate report.  The file top-N
+// lists only lose entries - nothing below their cut comes back in.  Oldest
+// and newest times are left alone since they cannot be recomputed without
+// the files.  Call Summarize afterwards for the directory lists.
+func (r *Result) Remove(m *Removal) {
+	parent := r.Root.Find(filepath.Dir(m.Path))
+	if parent != nil {
+		if m.IsDir {
+			parent.Children = slices.DeleteFunc(parent.Children, func(d *DirInfo) bool { return d.Name == m.Path })
+			parent.ImmDirs = subClamp(parent.ImmDirs, 1)
+		} else {
+			parent.ImmSize = subClamp(parent.ImmSize, m.Totals.Size)
+			parent.ImmBlocks = subClamp(parent.ImmBlocks, m.Totals.Allocated)
+			parent.ImmFiles = subClamp(parent.ImmFiles, 1)
+		}
+		for d := parent; d != nil; d = d.Parent {
+			d.RecSize = subClamp(d.RecSize, m.Totals.Size)
+			d.RecBlocks = subClamp(d.RecBlocks, m.Totals.Allocated)
+			d.RecFiles = subClamp(d.RecFiles, m.Totals.Files)
+			d.RecDirs = subClamp(d.RecDirs, m.Totals.Dirs)
+		}
+	}
+	r.Totals.Size = subClamp(r.Totals.Size, m.Totals.Size)
+	r.Totals.Allocated = subClamp(r.Totals.Allocated, m.Totals.Allocated)
+	r.Totals.Files = subClamp(r.Totals.Files, m.Totals.Files)
+	r.Totals.Dirs = subClamp(r.Totals.Dirs, m.Totals.Dirs)
+
+	for uid, lost := range m.Users {
+		if u, ok := r.Users[uid]; ok {
+			u.Size = subClamp(u.Size, lost.Size)
+			u.Blocks = subClamp(u.Blocks, lost.Blocks)
+			u.Files = subClamp(u.Files, lost.Files)
+			u.Dirs = subClamp(u.Dirs, lost.Dirs)
+			r.Users[uid] = u
+			if u.Files == 0 && u.Dirs == 0 {
+				delete(r.Users, uid)
+			}
+		}
+	}
+	for gid, lost := range m.Groups {
+		if g, ok := r.Groups[gid]; ok {
+			g.Size = subClamp(g.Size, lost.Size)
+			g.Blocks = subClamp(g.Blocks, lost.Blocks)
+			g.Files = subClamp(g.Files, lost.Files)
+			g.Dirs = subClamp(g.Dirs, lost.Dirs)
+			r.Groups[gid] = g
+			if g.Files == 0 && g.Dirs == 0 {
+				delete(r.Groups, gid)
+			}
+		}
+	}
+
+	top := r.topDirOf(m)
+	if len(m.Kinds) > 0 {
+		r.Kinds = subKinds(r.Kinds, m.Kinds, r.Options.Allocated)
+		if list, ok := r.KindsByTopDir[top]; ok {
+			if list = subKinds(list, m.Kinds, r.Options.Allocated); len(list) > 0 {
+				r.KindsByTopDir[top] = list
+			} else {
+				delete(r.KindsByTopDir, top)
+			}
+		}
+	}
+	if r.Options.AgeHistogram {
+		r.Ages.sub(&m.Ages)
+		if h, ok := r.AgesByTopDir[top]; ok {
+			h.sub(&m.Ages)
+			r.AgesByTopDir[top] = h
+			if h.Total().Files == 0 {
+				delete(r.AgesByTopDir, top)
+			}
+		}
+		for uid, lost := range m.AgesByUser {
+			if h, ok := r.AgesByUser[uid]; ok {
+				h.sub(&lost)
+				r.AgesByUser[uid] = h
+				if h.Total().Files == 0 {
+					delete(r.AgesByUser, uid)
+				}
+			}
+		}
+	}
+
+	gone := func(p PathSize) bool { return Within(p.Path, m.Path) }
+	r.LargestFiles = slices.DeleteFunc(r.LargestFiles, gone)
+	r.SparseFiles = slices.DeleteFunc(r.SparseFiles, gone)
+	for uid, ut := range r.UserTops {
+		ut.LargestFiles = slices.DeleteFunc(ut.LargestFiles, gone)
+		ut.DirsByOwnedSize = slices.DeleteFunc(ut.DirsByOwnedSize, gone)
+		if lost, ok := m.Users[uid]; ok && !m.IsDir {
+			ut.DirsByOwnedSize = subOwned(ut.DirsByOwnedSize, filepath.Dir(m.Path), int64(lost.Usage(r.Options.Allocated)))
+		}
+		r.UserTops[uid] = ut
+		if len(ut.LargestFiles) == 0 && len(ut.DirsByOwnedSize) == 0 {
+			delete(r.UserTops, uid)
+		}
+	}
+	if r.Duplicates != nil {
+		r.Duplicates.remove(m.Path, r.Options.TopN)
+	}
+}
+
+// topDirOf is the KindsByTopDir and AgesByTopDir key the files of m are under
+func (r *Result) topDirOf(m *Removal) string {
+	dir := m.Path
+	if !m.IsDir {
+		dir = filepath.Dir(dir)
+	}
+	rel, err := filepath.Rel(r.Root.Name, dir)
+	if err != nil || rel == "." {
+		return r.Root.Name
+	}
+	first, _, _ := strings.Cut(rel, string(filepath.Separator))
+	return filepath.Join(r.Root.Name, first)
+}
+
+// subKinds takes lost out of list and drops the kinds left without files
+func subKinds(list []KindStats, lost []KindStats, allocated bool) []KindStats {
+	for _, l := range lost {
+		i := slices.IndexFunc(list, func(k KindStats) bool { return k.Ext == l.Ext && k.Category == l.Category })
+		if i < 0 {
+			continue
+		}
+		k := &list[i]
+		k.Size = subClamp(k.Size, l.Size)
+		k.Blocks = subClamp(k.Blocks, l.Blocks)
+		k.Files = subClamp(k.Files, l.Files)
+	}
+	list = slices.DeleteFunc(list, func(k KindStats) bool { return k.Files == 0 })
+	sortKinds(list, allocated)
+	return list
+}
+
+// subOwned takes usage off the bytes owned in dir and keeps the list largest
+// first
+func subOwned(list []PathSize, dir string, usage int64) []PathSize {
+	i := slices.IndexFunc(list, func(p PathSize) bool { return p.Path == dir })
+	if i < 0 {
+		return list
+	}
+	list[i].Size -= usage
+	if list[i].Size <= 0 {
+		return slices.Delete(list, i, i+1)
+	}
+	slices.SortFunc(list, func(a, b PathSize) int {
+		switch {
+		case PathSizeLess(b, a):
+			return -1
+		case PathSizeLess(a, b):
+			return 1
+		}
+		return 0
+	})
+	return list
+}
+
+// Within is true when path is root or inside it
+func Within(path, root string) bool {
+	return path == root || strings.HasPrefix(path, root) && len(path) > len(root) && path[len(root)] == filepath.Separator
+}
+
+func subClamp(v uint64, d uint64) uint64 {
+	if d > v {
+		return 0
+	}
+	return v - d
+}
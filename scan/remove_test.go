@@ -0,0 +1,125 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// removeTree is a tree with a bit of everything Remove has to take back out,
+// without what is at or under skip
+func removeTree(now time.Time, skip string) *MemFS {
+	fsys := NewMemFS()
+	add := func(path string, f MemFile) {
+		if skip == "" || !Within(path, skip) {
+			fsys.Add(path, f)
+		}
+	}
+	add("/r/top.txt", MemFile{Size: 10, Allocated: 4096, ModTime: now.Add(-time.Hour), Uid: 1, Gid: 10})
+	add("/r/a/week.log", MemFile{Size: 20, ModTime: now.Add(-3 * day), Uid: 1, Gid: 10})
+	add("/r/a/old", MemFile{Mode: fs.ModeDir, Uid: 3, Gid: 30})
+	add("/r/a/old/x.bin", MemFile{Size: 300, ModTime: now.Add(-400 * day), Uid: 2, Gid: 20, Data: []byte("copy")})
+	add("/r/a/old/deep/y.mp4", MemFile{Size: 4000, Allocated: 8192, ModTime: now.Add(-5 * 365 * day), Uid: 2, Gid: 20})
+	add("/r/b/z.bin", MemFile{Size: 50000, Allocated: 4096, ModTime: now.Add(-200 * day), Uid: 2, Gid: 20})
+	add("/r/b/copy.bin", MemFile{Size: 300, ModTime: now.Add(-10 * day), Uid: 1, Gid: 10, Data: []byte("copy")})
+	add("/r/c/recent.txt", MemFile{Size: 7, ModTime: now.Add(-10 * day), Uid: 3, Gid: 30})
+	add("/r/c/again.bin", MemFile{Size: 300, ModTime: now.Add(-10 * day), Uid: 3, Gid: 30, Data: []byte("copy")})
+	return fsys
+}
+
+func removeOptions(fsys FS) Options {
+	return Options{FS: fsys, Threads: 2, TopN: 20, Categories: NewDefaultCategories(), AgeHistogram: true, UserTops: true, Duplicates: true}
+}
+
+// removing a path and rescanning without it must agree
+func TestRemoveMatchesRescan(t *testing.T) {
+	now := time.Now()
+	for _, path := range []string{"/r/a/old", "/r/b/z.bin", "/r/c", "/r/top.txt", "/r/a/week.log"} {
+		t.Run(path, func(t *testing.T) {
+			res, err := New(removeOptions(removeTree(now, ""))).Scan(context.Background(), "/r")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := res.Measure(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Skipped != 0 {
+				t.Errorf("skipped %d", m.Skipped)
+			}
+			res.Remove(m)
+			res.Summarize()
+
+			want, err := New(removeOptions(removeTree(now, path))).Scan(context.Background(), "/r")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Totals != want.Totals {
+				t.Errorf("totals %+v want %+v", res.Totals, want.Totals)
+			}
+			for _, c := range []struct {
+				name      string
+				got, want any
+			}{
+				{"rec size", res.Root.RecSize, want.Root.RecSize},
+				{"rec blocks", res.Root.RecBlocks, want.Root.RecBlocks},
+				{"rec files", res.Root.RecFiles, want.Root.RecFiles},
+				{"rec dirs", res.Root.RecDirs, want.Root.RecDirs},
+				{"users", res.Users, want.Users},
+				{"groups", res.Groups, want.Groups},
+				{"kinds", res.Kinds, want.Kinds},
+				{"kinds by top dir", res.KindsByTopDir, want.KindsByTopDir},
+				{"ages", res.Ages, want.Ages},
+				{"ages by user", res.AgesByUser, want.AgesByUser},
+				{"ages by top dir", res.AgesByTopDir, want.AgesByTopDir},
+				{"largest files", res.LargestFiles, want.LargestFiles},
+				{"sparse files", res.SparseFiles, want.SparseFiles},
+				{"user tops", res.UserTops, want.UserTops},
+				{"dirs by rec size", res.DirsByRecSize, want.DirsByRecSize},
+				{"dirs by imm files", res.DirsByImmFiles, want.DirsByImmFiles},
+				{"dup groups", res.Duplicates.Groups, want.Duplicates.Groups},
+				{"dup reclaimable", res.Duplicates.Reclaimable, want.Duplicates.Reclaimable},
+				{"dup dirs", res.Duplicates.DirsByReclaimable, want.Duplicates.DirsByReclaimable},
+			} {
+				if !reflect.DeepEqual(c.got, c.want) {
+					t.Errorf("%s\n got %v\nwant %v", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// a directory holding something the scan left out is flagged so it is not
+// removed as a whole
+func TestMeasureSkipped(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/f", MemFile{Size: 1})
+	fsys.Add("/r/a/keep/g", MemFile{Size: 2})
+	fsys.Add("/r/b/nfs/h", MemFile{Size: 3})
+	fsys.Add("/r/c/i", MemFile{Size: 4})
+	fsys.Add("/r/d/j", MemFile{Size: 5})
+	fsys.Add("/r/d/sub", MemFile{Mode: fs.ModeDir, ReadErr: fs.ErrPermission})
+	var exclude PatternList
+	exclude.Set("keep")
+	opts := Options{
+		FS:             fsys,
+		Exclude:        exclude,
+		Mounts:         map[string]*MountInfo{"/r/b/nfs": {MountPoint: "/r/b/nfs", FsType: "nfs"}},
+		ExcludeFsTypes: map[string]bool{"nfs": true},
+	}
+	res, err := New(opts).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]uint64{"/r/a": 1, "/r/b": 1, "/r/c": 0, "/r/d": 1, "/r/c/i": 0} {
+		m, err := res.Measure(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Skipped != want {
+			t.Errorf("%s skipped %d want %d", path, m.Skipped, want)
+		}
+	}
+}
@@ -0,0 +1,792 @@
+// Package scan walks a directory tree in parallel into a tree of DirInfo
+// with recursive totals, top-N lists, per user usage and error counts.
+//
+// All state belongs to a Scanner so any number of scans can run in one process:
+//
+//	s := scan.New(scan.Options{Threads: 8, TopN: 10})
+//	res, err := s.Scan(ctx, "/data")
+//
+// A cancelled ctx stops reading new directories but still returns what was
+// read - Result.Incomplete tells the two apart.
+//
+// The tree is read from the local disk unless Options.FS says otherwise -
+// MemFS is an in memory FS for tests.
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sflanaga/statticker"
+	"golang.org/x/sync/semaphore"
+)
+
+// Options configure a Scanner.  The zero value scans with one thread, keeps
+// no top-N entries and does no filtering.
+type Options struct {
+	// Threads limits the walker goroutines
+	Threads int
+	// TopN is the length of every top-N list in the Result
+	TopN int
+	// Allocated ranks top-N lists by allocated disk bytes (st_blocks*512)
+	// instead of the apparent size of files
+	Allocated bool
+	// DedupHardLinks counts files with more than one link only the first
+	// time their inode is seen - the default is apparent size like plain du -l
+	DedupHardLinks bool
+	// OneFileSystem stays on the filesystem of the root and skips other mounts
+	OneFileSystem bool
+	// Mounts is the mount table from LoadMounts keyed by mount point.  When nil
+	// a few special paths like /proc are skipped by name instead.
+	Mounts map[string]*MountInfo
+	// ExcludeFsTypes are filesystem types whose mounts are not scanned and
+	// IncludeFsTypes, when not empty, the only ones that are
+	ExcludeFsTypes map[string]bool
+	IncludeFsTypes map[string]bool
+	// Exclude applies to files and directories, Include only to files so
+	// directories are still descended to find matching files
+	Exclude PatternList
+	Include PatternList
+	// Stream, when set, rolls every directory up into its parent as soon as
+	// its subtree is complete and hands it over.  Its children are dropped
+	// after so memory only holds the part of the tree still being walked -
+	// the Result then has no tree below the root and no directory top-N lists.
+	// It is called from the walker goroutines so it must be safe for that.
+	Stream func(dir *DirInfo, depth int)
+	// Hooks are called during the walk - see Hooks for the ordering
+	Hooks Hooks
+	// FS is what the tree is read through - nil is the local disk
+	FS FS
+	// Archives descends into tar, tar.gz, tar.zst and zip files found by the
+	// walk as if they were directories.  A root that is an archive file is
+	// always read as one.
+	Archives bool
+	// Categories, when set, also tallies files by extension and category
+	// into Result.Kinds and Result.KindsByTopDir
+	Categories *Categories
+	// TimeField is the file time used for the oldest and newest times of
+	// directories and for ages - mtime by default
+	TimeField TimeField
+	// AgeHistogram tallies files by age into Result.Ages and the by user
+	// and by top-level directory histograms
+	AgeHistogram bool
+	// StaleAge, when not 0, lists directories with no file newer than it in
+	// Result.StaleDirs
+	StaleAge time.Duration
+	// UserTops tracks the largest files and the directories holding the most
+	// bytes of each user into Result.UserTops
+	UserTops bool
+	// UserTopsFor limits UserTops to these user ids - every user when empty
+	UserTopsFor []uint32
+	// Duplicates keeps every regular file of at least DupMinSize bytes, 1
+	// when not set, and after the walk reads the ones of the same size to
+	// find copies for Result.Duplicates.  The FS must be an Opener and files
+	// inside archives are not checked.
+	Duplicates bool
+	DupMinSize int64
+	// Debug gets per file and directory errors and skips as they happen
+	Debug io.Writer
+}
+
+// Progress are the live counters of the scan in flight, usable with a
+// statticker.Ticker
+type Progress struct {
+	Size       *statticker.Stat
+	Allocated  *statticker.Stat
+	Files      *statticker.Stat
+	Dirs       *statticker.Stat
+	Goroutines *statticker.Stat
+}
+
+// Totals of everything counted by a scan
+type Totals struct {
+	Size      uint64
+	Allocated uint64
+	Files     uint64
+	Dirs      uint64
+	// extra links not counted in the totals with Options.DedupHardLinks
+	HardLinkDups uint64
+	// excluded directories are never read so only excluded file bytes are known
+	ExcludedSize  uint64
+	ExcludedFiles uint64
+	ExcludedDirs  uint64
+}
+
+// Errors are the things that could not be counted
+type Errors struct {
+	FileStat     uint64
+	DirList      uint64
+	FilteredDirs uint64
+	NotDirOrFile uint64
+	// NotDirOrFile by file type name - symlink, pipe, socket and so on
+	ByFileType     map[string]uint64
+	SkippedMounts  []string
+	ExcludedMounts []string
+	// Archives that could not be read and were counted as plain files
+	Archives uint64
+}
+
+// Result of one scan.  The top-N lists are largest first.
+type Result struct {
+	Options Options
+	Root    *DirInfo
+	Start   time.Time
+	Elapsed time.Duration
+	// Incomplete is why the scan stopped early and Unfinished the directories
+	// it did not read - nil and empty for a complete scan
+	Incomplete error
+	Unfinished []string
+
+	Totals       Totals
+	Errors       Errors
+	Users        map[uint32]UserStats
+	Groups       map[uint32]GroupStats
+	UserSwitches uint64
+	// UserTops are with Options.UserTops
+	UserTops map[uint32]UserTop
+	// Duplicates is with Options.Duplicates
+	Duplicates *Duplicates
+	// mounts the scan counted something on, sorted by mount point
+	Mounts []*MountInfo
+	// archives read as directories, sorted by path
+	Archives []ArchiveInfo
+	// Kinds is usage by extension and category with Options.Categories and
+	// KindsByTopDir the same for each directory right below the root - files
+	// in the root itself are under the root.  Both are largest first.
+	Kinds         []KindStats
+	KindsByTopDir map[string][]KindStats
+	// Ages are with Options.AgeHistogram - by top-level directory like
+	// KindsByTopDir
+	Ages         AgeHistogram
+	AgesByUser   map[uint32]AgeHistogram
+	AgesByTopDir map[string]AgeHistogram
+
+	LargestFiles    []PathSize
+	SparseFiles     []PathSize
+	DirsByImmSize   []PathSize
+	DirsByImmFiles  []PathSize
+	DirsByImmDirs   []PathSize
+	DirsByRecSize   []PathSize
+	DirsByRecShared []PathSize
+	// StaleDirs are the largest directories with no file newer than
+	// Options.StaleAge.  Only the top of a stale subtree is listed, not every
+	// directory in it.
+	StaleDirs []PathSize
+}
+
+// Scanner runs one scan at a time - Scan can be called again to rescan from
+// zero.  Results of earlier scans are not touched by later ones.
+type Scanner struct {
+	opts     Options
+	fsys     FS
+	Progress Progress
+	running  atomic.Bool
+
+	// everything below is per scan and set up again by Scan
+	filestatErrors     atomic.Uint64
+	notDirOrFile       atomic.Uint64
+	filterDirs         atomic.Uint64
+	dirListErrors      atomic.Uint64
+	hardLinkDups       atomic.Uint64
+	skippedMounts      atomic.Uint64
+	excludedFiles      atomic.Uint64
+	excludedDirs       atomic.Uint64
+	excludedBytes      atomic.Uint64
+	userSwitches       atomic.Uint64
+	archiveErrors      atomic.Uint64
+	archiveDevs        atomic.Uint64
+	fileTypes          [len(fileTypeNames)]atomic.Uint64
+	users              *xsync.MapOf[uint32, UserStats]
+	groups             *xsync.MapOf[uint32, GroupStats]
+	userTops           *xsync.MapOf[uint32, *userTopLists]
+	dupFiles           *xsync.MapOf[int64, []*dupFile]
+	seenInodes         *xsync.MapOf[fileId, struct{}]
+	kinds              *xsync.MapOf[topKindKey, KindStats]
+	agesByTop          *xsync.MapOf[string, AgeHistogram]
+	agesByUser         *xsync.MapOf[uint32, AgeHistogram]
+	start              time.Time
+	largestFiles       *topFiles
+	sparseFiles        *topFiles
+	mounts             map[string]*MountInfo
+	rootDev            uint64
+	listMtx            sync.Mutex
+	unfinishedDirs     []string
+	skippedMountPaths  []string
+	excludedMountPaths []string
+	archives           []ArchiveInfo
+}
+
+// fileId identifies a file independent of the path(s) that reach it
+type fileId struct {
+	dev uint64
+	ino uint64
+}
+
+func New(opts Options) *Scanner {
+	opts.Threads = max(opts.Threads, 1)
+	fsys := opts.FS
+	if fsys == nil {
+		fsys = OSFS{}
+	}
+	return &Scanner{
+		opts: opts,
+		fsys: fsys,
+		Progress: Progress{
+			Size:       statticker.NewStat("bytes", statticker.Bytes),
+			Allocated:  statticker.NewStat("alloc", statticker.Bytes),
+			Files:      statticker.NewStat("files", statticker.Count),
+			Dirs:       statticker.NewStat("dir", statticker.Count),
+			Goroutines: statticker.NewStat("goroutines", statticker.Gauge),
+		},
+	}
+}
+
+// Running is true while Scan is walking
+func (s *Scanner) Running() bool {
+	return s.running.Load()
+}
+
+func (s *Scanner) reset() {
+	for _, stat := range []*statticker.Stat{s.Progress.Size, s.Progress.Allocated, s.Progress.Files, s.Progress.Dirs} {
+		stat.Add(-stat.Get())
+	}
+	for _, counter := range []*atomic.Uint64{&s.filestatErrors, &s.notDirOrFile, &s.filterDirs, &s.dirListErrors, &s.hardLinkDups,
+		&s.skippedMounts, &s.excludedFiles, &s.excludedDirs, &s.excludedBytes, &s.userSwitches, &s.archiveErrors} {
+		counter.Store(0)
+	}
+	for i := range s.fileTypes {
+		s.fileTypes[i].Store(0)
+	}
+	s.users = xsync.NewMapOf[uint32, UserStats]()
+	s.groups = xsync.NewMapOf[uint32, GroupStats]()
+	s.userTops = xsync.NewMapOf[uint32, *userTopLists]()
+	s.dupFiles = xsync.NewMapOf[int64, []*dupFile]()
+	s.seenInodes = xsync.NewMapOf[fileId, struct{}]()
+	s.kinds = xsync.NewMapOf[topKindKey, KindStats]()
+	s.agesByTop = xsync.NewMapOf[string, AgeHistogram]()
+	s.agesByUser = xsync.NewMapOf[uint32, AgeHistogram]()
+	s.largestFiles = newTopFiles(s.opts.TopN)
+	s.sparseFiles = newTopFiles(s.opts.TopN)
+	s.mounts = cloneMounts(s.opts.Mounts)
+	// Errors reads these while a live metrics handler serves a rescan
+	s.listMtx.Lock()
+	s.unfinishedDirs, s.skippedMountPaths, s.excludedMountPaths, s.archives = nil, nil, nil, nil
+	s.listMtx.Unlock()
+}
+
+func (s *Scanner) debugf(format string, args ...any) {
+	if s.opts.Debug != nil {
+		fmt.Fprintf(s.opts.Debug, format, args...)
+	}
+}
+
+// Scan walks root into a new tree and waits for all the workers.  A cancelled
+// ctx still returns the partial tree with Result.Incomplete set.
+func (s *Scanner) Scan(ctx context.Context, root string) (*Result, error) {
+	return s.scan(ctx, root, time.Now())
+}
+
+// scan is Scan with ages taken at start
+func (s *Scanner) scan(ctx context.Context, root string, start time.Time) (*Result, error) {
+	absPath, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	s.reset()
+	s.start = start
+	if s.opts.TimeField == TimeBirth {
+		if err := s.checkBirthTime(absPath); err != nil {
+			return nil, err
+		}
+	}
+	dir := NewDirInfo(absPath)
+	rootStat, rootStatErr := s.fsys.Stat(absPath)
+	if rootStatErr == nil {
+		sys := s.fsys.Sys(rootStat)
+		dir.Uid, dir.Gid = sys.Uid, sys.Gid
+	}
+	if s.opts.OneFileSystem {
+		if rootStatErr != nil {
+			return nil, rootStatErr
+		}
+		s.rootDev = s.fsys.Sys(rootStat).Dev
+	}
+	var rootMount *MountInfo
+	if s.mounts != nil {
+		rootMount = s.mountFor(absPath)
+	}
+	fsys := s.fsys
+	if rootStatErr == nil && rootStat.Mode().IsRegular() && ArchiveFormat(absPath) != "" {
+		var info ArchiveInfo
+		if fsys, info, err = s.openArchive(ctx, absPath, rootStat, s.fsys.Sys(rootStat)); err != nil {
+			return nil, err
+		}
+		s.addArchive(info)
+		rootMount = nil
+	}
+
+	s.running.Store(true)
+	defer s.running.Store(false)
+	var workerSema = semaphore.NewWeighted(int64(s.opts.Threads))
+	workerSema.Acquire(context.Background(), 1)
+	s.walkGo(ctx, dir, fsys, rootMount, workerSema, true, 0)
+
+	// must not use ctx here - a cancelled ctx would not wait for the workers
+	workerSema.Acquire(context.Background(), int64(s.opts.Threads))
+
+	if !s.rollsUp() {
+		treePerk(dir)
+	}
+	res := &Result{
+		Options:      s.opts,
+		Root:         dir,
+		Start:        start,
+		Elapsed:      time.Since(start),
+		Incomplete:   ctx.Err(),
+		Totals:       s.totals(),
+		Errors:       s.Errors(),
+		Users:        make(map[uint32]UserStats, s.users.Size()),
+		Groups:       make(map[uint32]GroupStats, s.groups.Size()),
+		UserSwitches: s.userSwitches.Load(),
+		Mounts:       s.touchedMounts(),
+		LargestFiles: s.largestFiles.list(),
+		SparseFiles:  s.sparseFiles.list(),
+	}
+	s.listMtx.Lock()
+	res.Unfinished = slices.Clone(s.unfinishedDirs)
+	res.Archives = slices.Clone(s.archives)
+	s.listMtx.Unlock()
+	slices.Sort(res.Unfinished)
+	slices.SortFunc(res.Archives, func(a, b ArchiveInfo) int { return strings.Compare(a.Path, b.Path) })
+	s.users.Range(func(key uint32, value UserStats) bool {
+		res.Users[key] = value
+		return true
+	})
+	s.groups.Range(func(key uint32, value GroupStats) bool {
+		res.Groups[key] = value
+		return true
+	})
+	for _, m := range res.Mounts {
+		m.Capacity, m.Free = FsSpace(m.MountPoint)
+	}
+	if s.opts.Categories != nil {
+		s.kindResults(res)
+	}
+	if s.opts.AgeHistogram {
+		s.ageResults(res)
+	}
+	if s.opts.UserTops {
+		s.userTopResults(res)
+	}
+	if opener, ok := s.fsys.(Opener); ok && s.opts.Duplicates {
+		res.Duplicates = s.findDuplicates(ctx, opener)
+	}
+	if s.opts.Stream == nil {
+		res.Summarize()
+	}
+	return res, nil
+}
+
+func (s *Scanner) totals() Totals {
+	return Totals{
+		Size:          uint64(s.Progress.Size.Get()),
+		Allocated:     uint64(s.Progress.Allocated.Get()),
+		Files:         uint64(s.Progress.Files.Get()),
+		Dirs:          uint64(s.Progress.Dirs.Get()),
+		HardLinkDups:  s.hardLinkDups.Load(),
+		ExcludedSize:  s.excludedBytes.Load(),
+		ExcludedFiles: s.excludedFiles.Load(),
+		ExcludedDirs:  s.excludedDirs.Load(),
+	}
+}
+
+// Errors are the error counts so far - safe to call while scanning
+func (s *Scanner) Errors() Errors {
+	errs := Errors{
+		FileStat:     s.filestatErrors.Load(),
+		DirList:      s.dirListErrors.Load(),
+		FilteredDirs: s.filterDirs.Load(),
+		NotDirOrFile: s.notDirOrFile.Load(),
+		ByFileType:   map[string]uint64{},
+		Archives:     s.archiveErrors.Load(),
+	}
+	for i := range s.fileTypes {
+		if n := s.fileTypes[i].Load(); n > 0 {
+			errs.ByFileType[fileTypeNames[i]] = n
+		}
+	}
+	s.listMtx.Lock()
+	errs.SkippedMounts = slices.Clone(s.skippedMountPaths)
+	errs.ExcludedMounts = slices.Clone(s.excludedMountPaths)
+	s.listMtx.Unlock()
+	slices.Sort(errs.SkippedMounts)
+	slices.Sort(errs.ExcludedMounts)
+	return errs
+}
+
+// Summarize rebuilds the directory top-N lists from the tree - Scan does this
+// already, it only needs calling again after changing the tree
+func (r *Result) Summarize() {
+	limit := r.Options.TopN
+	immSize := btree.NewG[PathSize](16, PathSizeLess)
+	immCount := btree.NewG[PathSize](16, PathSizeLess)
+	immDirCount := btree.NewG[PathSize](16, PathSizeLess)
+	recSize := btree.NewG[PathSize](16, PathSizeLess)
+	recShared := btree.NewG[PathSize](16, PathSizeLess)
+	stale := btree.NewG[PathSize](16, PathSizeLess)
+	staleCutoff := r.Start.Add(-r.Options.StaleAge).Unix()
+	var walk func(dir *DirInfo, parentStale bool)
+	walk = func(dir *DirInfo, parentStale bool) {
+		TrySetNewMaxPath(immSize, int64(dir.ImmUsage(r.Options.Allocated)), dir.Name, limit)
+		TrySetNewMaxPath(immCount, int64(dir.ImmFiles), dir.Name, limit)
+		TrySetNewMaxPath(immDirCount, int64(dir.ImmDirs), dir.Name, limit)
+		TrySetNewMaxPath(recSize, int64(dir.RecUsage(r.Options.Allocated)), dir.Name, limit)
+		if r.Options.DedupHardLinks && dir.RecShared > 0 {
+			TrySetNewMaxPath(recShared, int64(dir.RecShared), dir.Name, limit)
+		}
+		isStale := r.Options.StaleAge > 0 && dir.RecNewest != math.MinInt64 && dir.RecNewest < staleCutoff
+		if isStale && !parentStale {
+			TrySetNewMaxPath(stale, int64(dir.RecUsage(r.Options.Allocated)), dir.Name, limit)
+		}
+		for _, child := range dir.Children {
+			walk(child, isStale)
+		}
+	}
+	walk(r.Root, false)
+	r.DirsByImmSize = Descending(immSize)
+	r.DirsByImmFiles = Descending(immCount)
+	r.DirsByImmDirs = Descending(immDirCount)
+	r.DirsByRecSize = Descending(recSize)
+	r.DirsByRecShared = Descending(recShared)
+	r.StaleDirs = Descending(stale)
+}
+
+// GroupList is the groups sorted by usage, largest first
+func (r *Result) GroupList() []GroupStats {
+	list := make([]GroupStats, 0, len(r.Groups))
+	for _, g := range r.Groups {
+		list = append(list, g)
+	}
+	SortGroups(list, r.Options.Allocated)
+	return list
+}
+
+// UserList is the users sorted by usage, largest first
+func (r *Result) UserList() []UserStats {
+	list := make([]UserStats, 0, len(r.Users))
+	for _, u := range r.Users {
+		list = append(list, u)
+	}
+	SortUsers(list, r.Options.Allocated)
+	return list
+}
+
+// goofy special filters - only needed when the mount table is not available
+var fsFilter = map[string]bool{
+	"/proc": true,
+	"/dev":  true,
+	"/sys":  true,
+}
+
+// walkGo reads dir through fsys, which is only different from the scanner's
+// own FS inside an archive
+func (s *Scanner) walkGo(ctx context.Context, dir *DirInfo, fsys FS, mount *MountInfo, limitworkers *semaphore.Weighted, goroutine bool, depth int) {
+	if goroutine {
+		// we need to release the allocated thread/goroutine if we stop early
+		// we only need to do this when we did NOT steal the next directory/task
+		// also note that defer DOES work conditionally here because it works at
+		// the end of the current function and NOT the current scope
+		s.Progress.Goroutines.Add(1)
+		defer s.Progress.Goroutines.Add(-1)
+		defer limitworkers.Release(1)
+	}
+
+	// last defer so everything below has finished with dir
+	defer s.walkDone(dir, depth)
+
+	user := userTally{UserStats: UserStats{Uid: NullUserId}, s: s}
+	defer user.flush()
+	group := groupTally{GroupStats: GroupStats{Gid: NullUserId}, s: s}
+	defer group.flush()
+
+	var kinds *kindTally
+	if s.opts.Categories != nil {
+		kinds = &kindTally{kinds: map[kindKey]KindStats{}, s: s, top: topDir(dir, depth)}
+		defer kinds.flush()
+	}
+	var ages *ageTally
+	if s.opts.AgeHistogram {
+		ages = &ageTally{s: s, top: topDir(dir, depth), byUser: map[uint32]*AgeHistogram{}}
+		defer ages.flush()
+	}
+	var owned *ownedTally
+	if s.opts.UserTops {
+		owned = &ownedTally{s: s, dir: dir, owned: map[uint32]int64{}}
+		defer owned.flush()
+	}
+	var dups *dupTally
+	if s.opts.Duplicates && !inArchive(fsys) {
+		dups = &dupTally{s: s, files: map[int64][]*dupFile{}}
+		defer dups.flush()
+	}
+
+	// per mount totals are handed over once like the user stats
+	var mountSize, mountFiles uint64
+	if mount != nil {
+		defer func() {
+			mount.add(mountSize, mountFiles, 1)
+		}()
+	}
+
+	if _, local := fsys.(OSFS); local && depth <= 1 && s.mounts == nil {
+		if _, ok := fsFilter[dir.Name]; ok {
+			s.filterDirs.Add(1)
+			s.debugf("skipping path %s as special\n", dir.Name)
+			return
+		}
+	}
+
+	// once cancelled no new directories are read but the walk still unwinds
+	// so everything read so far makes it into the tree
+	if ctx.Err() != nil {
+		s.listMtx.Lock()
+		s.unfinishedDirs = append(s.unfinishedDirs, dir.Name)
+		s.listMtx.Unlock()
+		return
+	}
+
+	files, err := fsys.ReadDir(dir.Name)
+	if err != nil {
+		s.dirListErrors.Add(1)
+		s.debugf("Error reading directory: %v\n", err)
+		s.onError(dir.Name, err, depth)
+		return
+	}
+	newest := int64(math.MinInt64)
+	oldest := int64(math.MaxInt64)
+
+	for _, file := range files {
+		var cleanPath = filepath.Join(dir.Name, file.Name())
+		if s.isExcluded(file, cleanPath) {
+			s.countExcluded(file)
+			s.debugf("... excluding: %s\n", cleanPath)
+			continue
+		}
+		if file.IsDir() {
+			stats, err_st := file.Info()
+			if err_st == nil && s.opts.OneFileSystem && !inArchive(fsys) {
+				// a mount point reports the device of the mounted filesystem
+				if fsys.Sys(stats).Dev != s.rootDev {
+					s.addSkippedMount(cleanPath)
+					s.debugf("skipping path %s as it is on another filesystem\n", cleanPath)
+					continue
+				}
+			}
+
+			subMount := mount
+			if s.mounts != nil && !inArchive(fsys) {
+				if m, ok := s.mounts[cleanPath]; ok {
+					if s.mountExcluded(m) {
+						s.addExcludedMount(m)
+						s.debugf("skipping mount %s of type %s\n", cleanPath, m.FsType)
+						continue
+					}
+					subMount = m
+				}
+			}
+
+			if s.opts.Hooks.OnDir != nil && !s.opts.Hooks.OnDir(cleanPath, file, depth+1) {
+				s.debugf("... pruned by hook: %s\n", cleanPath)
+				continue
+			}
+
+			subdir := s.addSubdir(dir, cleanPath)
+			if err_st == nil {
+				sys := fsys.Sys(stats)
+				subdir.Uid, subdir.Gid = sys.Uid, sys.Gid
+				user.addDir(sys.Uid)
+				group.addDir(sys.Gid)
+			} else {
+				s.debugf("error on %s of %v\n", cleanPath, err_st)
+				s.onError(cleanPath, err_st, depth+1)
+			}
+			s.walkSubdir(ctx, subdir, fsys, subMount, limitworkers, depth+1)
+		} else if file.Type().IsRegular() || (fs.ModeIrregular&file.Type() != 0) {
+			stats, err_st := file.Info()
+			if err_st != nil {
+				s.filestatErrors.Add(1)
+				s.debugf("... Error reading file info: %v\n", err_st)
+				s.onError(cleanPath, err_st, depth+1)
+				continue
+			}
+			sys := fsys.Sys(stats)
+			// an archive inside an archive has no path on disk to open
+			if s.opts.Archives && !inArchive(fsys) && ArchiveFormat(file.Name()) != "" {
+				if s.walkArchive(ctx, dir, cleanPath, file, stats, sys, limitworkers, depth+1) {
+					user.addDir(sys.Uid)
+					group.addDir(sys.Gid)
+					continue
+				}
+			}
+			if s.opts.Hooks.OnFile != nil {
+				s.opts.Hooks.OnFile(cleanPath, stats, depth+1)
+			}
+			sz := stats.Size()
+			alloc := sys.Allocated
+			// with hard link dedup only the first link seen for an inode is counted
+			// later links only add to the shared bytes of the directory holding them
+			firstLink := true
+			if s.opts.DedupHardLinks {
+				if sys.Nlink > 1 {
+					if _, seen := s.seenInodes.LoadOrStore(fileId{sys.Dev, sys.Ino}, struct{}{}); seen {
+						firstLink = false
+						s.hardLinkDups.Add(1)
+						dir.ImmShared += uint64(sz)
+						dir.RecShared += uint64(sz)
+					}
+				}
+			}
+			ftime := fileTime(s.opts.TimeField, fsys, cleanPath, stats, sys)
+			newest = max(newest, ftime)
+			oldest = min(oldest, ftime)
+			s.Progress.Files.Add(1)
+			if firstLink {
+				s.Progress.Size.Add(int64(sz))
+				s.Progress.Allocated.Add(alloc)
+				dir.ImmSize += uint64(sz)
+				dir.RecSize += uint64(sz)
+				dir.ImmBlocks += uint64(alloc)
+				dir.RecBlocks += uint64(alloc)
+				mountSize += uint64(sz)
+			}
+			mountFiles++
+
+			dir.ImmFiles++
+			dir.RecFiles++
+
+			dir.ImmNewest = newest
+			dir.ImmOldest = oldest
+
+			dir.RecNewest = max(dir.RecNewest, newest)
+			dir.RecOldest = min(dir.RecOldest, oldest)
+
+			uid := sys.Uid
+			if firstLink {
+				usage := sz
+				if s.opts.Allocated {
+					usage = alloc
+				}
+				s.largestFiles.setMaxFile(usage, &cleanPath)
+				if owned != nil {
+					owned.addFile(uid, usage, &cleanPath)
+				}
+				if dups != nil && file.Type().IsRegular() {
+					dups.addFile(cleanPath, sz, sys)
+				}
+				if sz > alloc {
+					s.sparseFiles.setMaxFile(sz-alloc, &cleanPath)
+				}
+				user.addFile(uid, uint64(sz), uint64(alloc))
+				group.addFile(sys.Gid, uint64(sz), uint64(alloc))
+				if kinds != nil {
+					kinds.addFile(file.Name(), cleanPath, uint64(sz), uint64(alloc))
+				}
+				if ages != nil {
+					ages.addFile(uid, ftime, uint64(sz), uint64(alloc))
+				}
+			} else {
+				user.addFile(uid, 0, 0)
+				group.addFile(sys.Gid, 0, 0)
+				if kinds != nil {
+					kinds.addFile(file.Name(), cleanPath, 0, 0)
+				}
+				if ages != nil {
+					ages.addFile(uid, ftime, 0, 0)
+				}
+			}
+		} else {
+			s.notDirOrFile.Add(1)
+			s.fileTypes[fileTypeIndex(file.Type())].Add(1)
+			s.debugf("... skipping file: %s  type: %s\n", cleanPath, fileTypeNames[fileTypeIndex(file.Type())])
+		}
+	}
+}
+
+// addSubdir hangs a new directory off dir for the caller to walk next
+func (s *Scanner) addSubdir(dir *DirInfo, path string) *DirInfo {
+	subdir := NewDirInfo(path)
+	subdir.Parent = dir
+	atomic.AddInt32(&dir.pending, 1)
+	dir.Children = append(dir.Children, subdir)
+	s.Progress.Dirs.Add(1)
+	dir.ImmDirs++
+	dir.RecDirs++
+	return subdir
+}
+
+// walkSubdir walks on a new goroutine when a worker is free and in this one
+// otherwise - cheesey simple work-stealing
+func (s *Scanner) walkSubdir(ctx context.Context, subdir *DirInfo, fsys FS, mount *MountInfo, limitworkers *semaphore.Weighted, depth int) {
+	if limitworkers.TryAcquire(1) {
+		go s.walkGo(ctx, subdir, fsys, mount, limitworkers, true, depth)
+	} else {
+		s.walkGo(ctx, subdir, fsys, mount, limitworkers, false, depth)
+	}
+}
+
+// walkArchive reads an archive found by the walk and walks it as a
+// subdirectory of dir.  It is false when the archive is left for the caller
+// to count as a plain file because it could not be read or OnDir pruned it.
+// It is only a directory to the hooks once it is walked as one.
+func (s *Scanner) walkArchive(ctx context.Context, dir *DirInfo, path string, entry fs.DirEntry, info fs.FileInfo, sys FileSys,
+	limitworkers *semaphore.Weighted, depth int) bool {
+	afs, ainfo, err := s.openArchive(ctx, path, info, sys)
+	if err != nil {
+		s.archiveErrors.Add(1)
+		s.debugf("... %v\n", err)
+		s.onError(path, err, depth)
+		return false
+	}
+	if s.opts.Hooks.OnDir != nil && !s.opts.Hooks.OnDir(path, entry, depth) {
+		s.debugf("... pruned by hook: %s\n", path)
+		return false
+	}
+	s.addArchive(ainfo)
+	subdir := s.addSubdir(dir, path)
+	subdir.Uid, subdir.Gid = sys.Uid, sys.Gid
+	s.walkSubdir(ctx, subdir, afs, nil, limitworkers, depth)
+	return true
+}
+
+// fileTypeNames are the names of the kinds of entries that are neither
+// file nor directory
+var fileTypeNames = [...]string{"symlink", "pipe", "socket", "char-device", "device", "irregular", "unknown"}
+
+func fileTypeIndex(mode fs.FileMode) int {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return 0
+	case mode&fs.ModeNamedPipe != 0:
+		return 1
+	case mode&fs.ModeSocket != 0:
+		return 2
+	case mode&fs.ModeCharDevice != 0:
+		return 3
+	case mode&fs.ModeDevice != 0:
+		return 4
+	case mode&fs.ModeIrregular != 0:
+		return 5
+	}
+	return 6
+}
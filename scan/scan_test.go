@@ -0,0 +1,325 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func makeTree(t *testing.T, files map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, size := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// two scans in one process must not see each other's counts
+func TestConcurrentScanners(t *testing.T) {
+	roots := []string{
+		makeTree(t, map[string]int{"a/x": 100, "a/b/y": 200}),
+		makeTree(t, map[string]int{"z": 5000}),
+	}
+	want := []Totals{{Size: 300, Files: 2, Dirs: 2}, {Size: 5000, Files: 1}}
+	results := make([]*Result, len(roots))
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := New(Options{Threads: 2, TopN: 5}).Scan(context.Background(), root)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	for i, res := range results {
+		if res == nil {
+			continue
+		}
+		got := res.Totals
+		got.Allocated = 0
+		if got != want[i] {
+			t.Errorf("scan of %s: got %+v want %+v", roots[i], got, want[i])
+		}
+		if res.Root.RecSize != want[i].Size {
+			t.Errorf("scan of %s: root RecSize %d want %d", roots[i], res.Root.RecSize, want[i].Size)
+		}
+		if len(res.LargestFiles) != int(want[i].Files) {
+			t.Errorf("scan of %s: largest files %v", roots[i], res.LargestFiles)
+		}
+	}
+}
+
+// a rescan starts from zero and leaves the earlier Result alone
+func TestRescanKeepsOldResult(t *testing.T) {
+	root := makeTree(t, map[string]int{"f": 100})
+	s := New(Options{TopN: 5})
+	first, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "g"), make([]byte, 50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Totals.Size != 100 || len(first.LargestFiles) != 1 {
+		t.Errorf("first result changed: %+v %v", first.Totals, first.LargestFiles)
+	}
+	if second.Totals.Size != 150 || second.Totals.Files != 2 {
+		t.Errorf("rescan totals %+v", second.Totals)
+	}
+}
+
+// a live metrics handler reads Errors while -serve rescans
+func TestErrorsDuringRescan(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/f", MemFile{Size: 1})
+	fsys.Add("/r/nfs/g", MemFile{Size: 2})
+	s := New(Options{
+		FS:             fsys,
+		Mounts:         map[string]*MountInfo{"/r/nfs": {MountPoint: "/r/nfs", FsType: "nfs"}},
+		ExcludeFsTypes: map[string]bool{"nfs": true},
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			if _, err := s.Scan(context.Background(), "/r"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if errs := s.Errors(); !slices.Equal(errs.ExcludedMounts, []string{"/r/nfs (nfs)"}) {
+				t.Errorf("excluded mounts %v", errs.ExcludedMounts)
+			}
+			return
+		default:
+			s.Errors()
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	root := makeTree(t, map[string]int{"r": 7, "a/x": 100, "a/b/y": 200, "skip/z": 1000})
+	var mtx sync.Mutex
+	var files []string
+	var done []string
+	doneSize := map[string]uint64{}
+	s := New(Options{Threads: 4, TopN: 5, Hooks: Hooks{
+		OnDir: func(path string, entry fs.DirEntry, depth int) bool {
+			return entry.Name() != "skip"
+		},
+		OnFile: func(path string, info fs.FileInfo, depth int) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, fmt.Sprintf("%s:%d:%d", rel, depth, info.Size()))
+		},
+		OnDirDone: func(dir *DirInfo, depth int) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			rel, _ := filepath.Rel(root, dir.Name)
+			done = append(done, rel)
+			doneSize[rel] = dir.RecSize
+		},
+	}})
+	res, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{"a/b/y:3:200", "a/x:2:100", "r:1:7"}; !slices.Equal(files, want) {
+		t.Errorf("OnFile got %v want %v", files, want)
+	}
+	// children always finish before their parent
+	if want := []string{"a/b", "a", "."}; !slices.Equal(done, want) {
+		t.Errorf("OnDirDone order %v want %v", done, want)
+	}
+	if doneSize["a"] != 300 || doneSize["."] != 307 {
+		t.Errorf("OnDirDone saw incomplete totals %v", doneSize)
+	}
+	// the pruned directory is not in the totals or the tree
+	if res.Totals.Size != 307 || res.Totals.Dirs != 2 || res.Root.RecSize != 307 {
+		t.Errorf("pruned totals %+v root %d", res.Totals, res.Root.RecSize)
+	}
+	if res.Root.Find(filepath.Join(root, "skip")) != nil {
+		t.Error("pruned directory still in the tree")
+	}
+	if len(res.DirsByRecSize) != 3 {
+		t.Errorf("directory top-N missing with OnDirDone %v", res.DirsByRecSize)
+	}
+}
+
+func TestOnErrorHook(t *testing.T) {
+	root := makeTree(t, map[string]int{"f": 1})
+	missing := filepath.Join(root, "gone")
+	var got []string
+	s := New(Options{Hooks: Hooks{
+		OnError: func(path string, err error, depth int) {
+			got = append(got, fmt.Sprintf("%s:%d", path, depth))
+		},
+	}})
+	res, err := s.Scan(context.Background(), missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{missing + ":0"}; !slices.Equal(got, want) {
+		t.Errorf("OnError got %v want %v", got, want)
+	}
+	if res.Errors.DirList != 1 {
+		t.Errorf("DirList errors %d", res.Errors.DirList)
+	}
+}
+
+func TestErrorCounts(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/ok", MemFile{Size: 10})
+	fsys.Add("/r/locked", MemFile{Mode: fs.ModeDir, ReadErr: fs.ErrPermission})
+	fsys.Add("/r/gone", MemFile{Size: 99, StatErr: fs.ErrNotExist})
+	fsys.Add("/r/link", MemFile{Mode: fs.ModeSymlink})
+	fsys.Add("/r/a/link2", MemFile{Mode: fs.ModeSymlink})
+	fsys.Add("/r/a/fifo", MemFile{Mode: fs.ModeNamedPipe})
+
+	var hooked []string
+	var mtx sync.Mutex
+	res, err := New(Options{FS: fsys, Threads: 2, Hooks: Hooks{
+		OnError: func(path string, err error, depth int) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			hooked = append(hooked, path)
+		},
+	}}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Errors{
+		FileStat:     1,
+		DirList:      1,
+		NotDirOrFile: 3,
+		ByFileType:   map[string]uint64{"symlink": 2, "pipe": 1},
+	}
+	got := res.Errors
+	if got.FileStat != want.FileStat || got.DirList != want.DirList || got.NotDirOrFile != want.NotDirOrFile ||
+		!maps.Equal(got.ByFileType, want.ByFileType) || got.FilteredDirs != 0 {
+		t.Errorf("errors got %+v want %+v", got, want)
+	}
+	slices.Sort(hooked)
+	if !slices.Equal(hooked, []string{"/r/gone", "/r/locked"}) {
+		t.Errorf("OnError paths %v", hooked)
+	}
+	// an unreadable directory is still a directory of the tree
+	if res.Totals != (Totals{Size: 10, Files: 1, Dirs: 2}) {
+		t.Errorf("totals %+v", res.Totals)
+	}
+}
+
+func TestExcludeAndInclude(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/keep.go", MemFile{Size: 1})
+	fsys.Add("/r/skip.log", MemFile{Size: 20})
+	fsys.Add("/r/node_modules/x.go", MemFile{Size: 300})
+	fsys.Add("/r/src/y.go", MemFile{Size: 4000})
+	fsys.Add("/r/src/y.txt", MemFile{Size: 50000})
+
+	var opts Options
+	opts.FS = fsys
+	for _, p := range []string{"node_modules", "*.log"} {
+		if err := opts.Exclude.Set(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := opts.Include.Set("*.go"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := New(opts).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Totals{Size: 4001, Files: 2, Dirs: 1, ExcludedSize: 50020, ExcludedFiles: 2, ExcludedDirs: 1}
+	if res.Totals != want {
+		t.Errorf("totals got %+v want %+v", res.Totals, want)
+	}
+}
+
+func TestHardLinkDedup(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/orig", MemFile{Size: 1000, Allocated: 1024, Ino: 7})
+	fsys.Add("/r/b/link", MemFile{Size: 1000, Allocated: 1024, Ino: 7})
+	fsys.Add("/r/b/other", MemFile{Size: 5, Allocated: 512})
+
+	res, err := New(Options{FS: fsys, TopN: 5}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Totals.Size != 2005 || res.Totals.HardLinkDups != 0 {
+		t.Errorf("without dedup %+v", res.Totals)
+	}
+
+	// only one of the two links is counted - which one depends on the walk
+	res, err = New(Options{FS: fsys, TopN: 5, DedupHardLinks: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Totals.Size != 1005 || res.Totals.Allocated != 1536 || res.Totals.Files != 3 || res.Totals.HardLinkDups != 1 {
+		t.Errorf("with dedup %+v", res.Totals)
+	}
+	if res.Root.RecShared != 1000 || len(res.DirsByRecShared) == 0 {
+		t.Errorf("shared bytes %d %v", res.Root.RecShared, res.DirsByRecShared)
+	}
+	if u := res.Users[0]; u.Size != 1005 || u.Files != 3 {
+		t.Errorf("user with dedup %+v", u)
+	}
+}
+
+func TestOneFileSystem(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/f", MemFile{Size: 1})
+	fsys.Add("/r/mnt", MemFile{Mode: fs.ModeDir, Dev: 2})
+	fsys.Add("/r/mnt/big", MemFile{Size: 1000, Dev: 2})
+
+	res, err := New(Options{FS: fsys, OneFileSystem: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Totals.Size != 1 || !slices.Equal(res.Errors.SkippedMounts, []string{"/r/mnt"}) {
+		t.Errorf("totals %+v skipped %v", res.Totals, res.Errors.SkippedMounts)
+	}
+}
+
+func TestCancelledScan(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/f", MemFile{Size: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := New(Options{FS: fsys}).Scan(ctx, "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Incomplete != context.Canceled || !slices.Equal(res.Unfinished, []string{"/r"}) {
+		t.Errorf("incomplete %v unfinished %v", res.Incomplete, res.Unfinished)
+	}
+}
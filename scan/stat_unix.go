@@ -0,0 +1,31 @@
+//go:build darwin || freebsd || netbsd || openbsd || linux
+// +build darwin freebsd netbsd openbsd linux
+
+package scan
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// FileOwner is the user id owning the file
+func FileOwner(fileInfo fs.FileInfo) uint32 {
+	uid := fileInfo.Sys().(*syscall.Stat_t).Uid
+	return uid
+}
+
+// FileGroup is the group id of the file
+func FileGroup(fileInfo fs.FileInfo) uint32 {
+	return fileInfo.Sys().(*syscall.Stat_t).Gid
+}
+
+func getFileId(fileInfo fs.FileInfo) (fileId, uint64) {
+	st := fileInfo.Sys().(*syscall.Stat_t)
+	return fileId{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink)
+}
+
+// FileAllocated is the bytes actually allocated on disk - st_blocks is always
+// in 512 byte units regardless of the filesystem block size
+func FileAllocated(fileInfo fs.FileInfo) int64 {
+	return fileInfo.Sys().(*syscall.Stat_t).Blocks * 512
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package scan
+
+import (
+	"io/fs"
+)
+
+// FileOwner is the user id owning the file
+func FileOwner(fileInfo fs.FileInfo) uint32 {
+	// 	var uid uint32
+	// 	fileBasicInfo, err := syscall.GetFileInformationByHandle(fileInfo.Sys().(*syscall.Handle))
+	// 	if err != nil {
+	// 		return 0, fmt.Errorf("failed to get file information: %w", err)
+	// 	}
+	// 	uid = fileBasicInfo.Owner.LowPart
+	// 	return uid
+	//
+	return 0
+}
+
+// FileGroup is the group id of the file - always 0 like FileOwner
+func FileGroup(fileInfo fs.FileInfo) uint32 {
+	return 0
+}
+
+func getFileId(fileInfo fs.FileInfo) (fileId, uint64) {
+	// no inode info through FileInfo on windows so every file looks unique
+	return fileId{}, 1
+}
+
+// FileAllocated is the bytes allocated on disk
+func FileAllocated(fileInfo fs.FileInfo) int64 {
+	// no block count through FileInfo on windows so fall back to apparent size
+	return fileInfo.Size()
+}
@@ -0,0 +1,14 @@
+//go:build linux || openbsd
+
+package scan
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileTimes are the access and status change times in unix seconds
+func fileTimes(fileInfo fs.FileInfo) (atime, ctime int64) {
+	st := fileInfo.Sys().(*syscall.Stat_t)
+	return int64(st.Atim.Sec), int64(st.Ctim.Sec)
+}
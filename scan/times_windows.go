@@ -0,0 +1,17 @@
+//go:build windows
+
+package scan
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileTimes are the access and status change times in unix seconds - there
+// is no status change time on windows so it is the modify time
+func fileTimes(fileInfo fs.FileInfo) (atime, ctime int64) {
+	if attr, ok := fileInfo.Sys().(*syscall.Win32FileAttributeData); ok {
+		return attr.LastAccessTime.Nanoseconds() / 1e9, fileInfo.ModTime().Unix()
+	}
+	return fileInfo.ModTime().Unix(), fileInfo.ModTime().Unix()
+}
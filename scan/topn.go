@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/btree"
+)
+
+// PathSize is one entry of a top-N list - Size is bytes or a count depending
+// on the list
+type PathSize struct {
+	Size int64
+	Path string
+}
+
+// PathSizeLess orders by size and then by path backwards so the minimum the
+// lists drop first is the last path of the smallest size, and equal sizes
+// come out by path when listed descending
+func PathSizeLess(a, b PathSize) bool {
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return a.Path > b.Path
+}
+
+// topFiles tracks the largest N files as the walk finds them.  Only sizes
+// above 0 are kept.  Once it holds N files minFile is the smallest of them so
+// anything smaller is turned away without taking the lock - which is nearly
+// every file of a large scan.
+type topFiles struct {
+	limit   int
+	mtx     sync.Mutex
+	minFile atomic.Int64
+	mapMax  *btree.BTreeG[PathSize]
+}
+
+func newTopFiles(limit int) *topFiles {
+	m := &topFiles{
+		limit:  limit,
+		mapMax: btree.NewG[PathSize](16, PathSizeLess),
+	}
+	if limit <= 0 {
+		m.minFile.Store(math.MaxInt64)
+	}
+	return m
+}
+
+func (m *topFiles) setMaxFile(size int64, path *string) {
+	// sizes equal to the minimum still go in when their path sorts first
+	if size <= 0 || size < m.minFile.Load() {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.mapMax.ReplaceOrInsert(PathSize{Size: size, Path: *path})
+	if m.mapMax.Len() > m.limit {
+		m.mapMax.DeleteMin()
+	}
+	if m.mapMax.Len() == m.limit {
+		least, _ := m.mapMax.Min()
+		m.minFile.Store(least.Size)
+	}
+}
+
+func (m *topFiles) list() []PathSize {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return Descending(m.mapMax)
+}
+
+// TrySetNewMaxPath adds to a top-N tree ordered by PathSizeLess and drops its
+// minimum once it holds more than limit
+func TrySetNewMaxPath(tree *btree.BTreeG[PathSize], size int64, path string, limit int) {
+	tree.ReplaceOrInsert(PathSize{size, path})
+	if tree.Len() > limit {
+		tree.DeleteMin()
+	}
+}
+
+// Descending lists a top-N tree largest first
+func Descending(tree *btree.BTreeG[PathSize]) []PathSize {
+	list := make([]PathSize, 0, tree.Len())
+	tree.Descend(func(value PathSize) bool {
+		list = append(list, value)
+		return true
+	})
+	return list
+}
@@ -0,0 +1,205 @@
+package scan
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func paths(list []PathSize) []string {
+	var out []string
+	for _, p := range list {
+		out = append(out, fmt.Sprintf("%s=%d", p.Path, p.Size))
+	}
+	return out
+}
+
+func TestTopN(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/f1", MemFile{Size: 500, Allocated: 512})
+	fsys.Add("/r/a/f2", MemFile{Size: 40, Allocated: 4096})
+	fsys.Add("/r/a/f3", MemFile{Size: 3, Allocated: 1024})
+	fsys.Add("/r/b/sparse", MemFile{Size: 1 << 20, Allocated: 8192})
+	fsys.Add("/r/b/c/f4", MemFile{Size: 60, Allocated: 512})
+	fsys.Add("/r/b/c/d/f5", MemFile{Size: 7, Allocated: 512})
+
+	res, err := New(Options{FS: fsys, Threads: 2, TopN: 3}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		got  []PathSize
+		want []string
+	}{
+		{"largest files", res.LargestFiles, []string{"/r/b/sparse=1048576", "/r/a/f1=500", "/r/b/c/f4=60"}},
+		{"sparse files", res.SparseFiles, []string{"/r/b/sparse=1040384"}},
+		{"dirs by imm size", res.DirsByImmSize, []string{"/r/b=1048576", "/r/a=543", "/r/b/c=60"}},
+		{"dirs by rec size", res.DirsByRecSize, []string{"/r=1049186", "/r/b=1048643", "/r/a=543"}},
+	} {
+		if got := paths(c.got); !slices.Equal(got, c.want) {
+			t.Errorf("%s got %v want %v", c.name, got, c.want)
+		}
+	}
+
+	// ranking by allocated bytes instead
+	res, err = New(Options{FS: fsys, TopN: 2, Allocated: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(res.LargestFiles), []string{"/r/b/sparse=8192", "/r/a/f2=4096"}; !slices.Equal(got, want) {
+		t.Errorf("allocated largest files got %v want %v", got, want)
+	}
+	if got, want := paths(res.DirsByRecSize), []string{"/r=14848", "/r/b=9216"}; !slices.Equal(got, want) {
+		t.Errorf("allocated dirs by rec size got %v want %v", got, want)
+	}
+}
+
+func TestTopNZeroLimit(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/f", MemFile{Size: 10})
+	res, err := New(Options{FS: fsys}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.LargestFiles) != 0 || len(res.DirsByRecSize) != 0 {
+		t.Errorf("lists with TopN 0: %v %v", res.LargestFiles, res.DirsByRecSize)
+	}
+	if res.Totals.Size != 10 {
+		t.Errorf("totals %+v", res.Totals)
+	}
+}
+
+func TestTopNTies(t *testing.T) {
+	fsys := NewMemFS()
+	for _, name := range []string{"/r/d/z", "/r/b/y", "/r/a/x", "/r/c/w"} {
+		fsys.Add(name, MemFile{Size: 100})
+	}
+	fsys.Add("/r/big", MemFile{Size: 200})
+	res, err := New(Options{FS: fsys, Threads: 2, TopN: 3}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(res.LargestFiles), []string{"/r/big=200", "/r/a/x=100", "/r/b/y=100"}; !slices.Equal(got, want) {
+		t.Errorf("largest files got %v want %v", got, want)
+	}
+	if got, want := paths(res.DirsByImmSize), []string{"/r=200", "/r/a=100", "/r/b=100"}; !slices.Equal(got, want) {
+		t.Errorf("dirs by imm size got %v want %v", got, want)
+	}
+}
+
+// exactTopN is what topFiles must match - sizes above 0, largest first and
+// equal sizes by path
+func exactTopN(files []PathSize, limit int) []PathSize {
+	files = slices.DeleteFunc(slices.Clone(files), func(f PathSize) bool { return f.Size <= 0 })
+	slices.SortFunc(files, func(a, b PathSize) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files[:min(len(files), limit)]
+}
+
+func randomFiles(n int, maxSize int64) []PathSize {
+	files := make([]PathSize, n)
+	for i := range files {
+		files[i] = PathSize{rand.Int64N(maxSize), fmt.Sprintf("/f/%d", i)}
+	}
+	return files
+}
+
+func TestTopFilesMatchesSort(t *testing.T) {
+	for _, c := range []struct {
+		files   int
+		maxSize int64
+		limit   int
+	}{
+		{100, 1 << 30, 10},
+		{5000, 50, 10}, // mostly ties
+		{5000, 1 << 20, 1},
+		{5000, 1 << 20, 100},
+		{3, 10, 10},
+		{100, 10, 0},
+	} {
+		files := randomFiles(c.files, c.maxSize)
+		top := newTopFiles(c.limit)
+		var wg sync.WaitGroup
+		for w := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := w; i < len(files); i += 8 {
+					top.setMaxFile(files[i].Size, &files[i].Path)
+				}
+			}()
+		}
+		wg.Wait()
+		want := exactTopN(files, c.limit)
+		if got := top.list(); !slices.Equal(got, want) {
+			t.Errorf("%+v: got %v want %v", c, paths(got), paths(want))
+		}
+		if c.limit > 0 && len(want) == c.limit && top.minFile.Load() != want[len(want)-1].Size {
+			t.Errorf("%+v: threshold %d is not the smallest kept %d", c, top.minFile.Load(), want[len(want)-1].Size)
+		}
+	}
+}
+
+// lockedTopFiles takes the lock for every file the way topFiles did when
+// its threshold never moved off 0
+type lockedTopFiles struct {
+	*topFiles
+}
+
+func (m *lockedTopFiles) setMaxFile(size int64, path *string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.mapMax.ReplaceOrInsert(PathSize{Size: size, Path: *path})
+	if m.mapMax.Len() > m.limit {
+		m.mapMax.DeleteMin()
+	}
+}
+
+// the benchmarks offer a million files from parallel walkers like a large
+// scan at a high -t, e.g. go test -bench TopFiles -cpu 1,8,32
+func benchmarkTopFiles(b *testing.B, setMaxFile func(int64, *string)) {
+	files := randomFiles(1_000_000, 1<<40)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.IntN(len(files))
+		for pb.Next() {
+			f := &files[i%len(files)]
+			setMaxFile(f.Size, &f.Path)
+			i++
+		}
+	})
+}
+
+func BenchmarkTopFiles(b *testing.B) {
+	benchmarkTopFiles(b, newTopFiles(10).setMaxFile)
+}
+
+func BenchmarkTopFilesLocked(b *testing.B) {
+	m := &lockedTopFiles{newTopFiles(10)}
+	benchmarkTopFiles(b, m.setMaxFile)
+}
+
+// BenchmarkScanManyFiles is a whole scan of a million files at -t 32
+func BenchmarkScanManyFiles(b *testing.B) {
+	fsys := NewMemFS()
+	for i, f := range randomFiles(1_000_000, 1<<30) {
+		fsys.Add(fmt.Sprintf("/r/%d/%d", i%1000, i), MemFile{Size: f.Size, Allocated: f.Size})
+	}
+	scanner := New(Options{FS: fsys, Threads: 32, TopN: 10})
+	b.ResetTimer()
+	for range b.N {
+		if _, err := scanner.Scan(context.Background(), "/r"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package scan
+
+import (
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// DirInfo is one directory of the scanned tree.  The Imm fields cover files
+// immediately in the directory and the Rec fields everything under it.  The
+// oldest and newest times are unix seconds of the Options.TimeField of files
+// - math.MaxInt64 and math.MinInt64 when there were no files.
+type DirInfo struct {
+	Name      string
+	ImmSize   uint64
+	ImmFiles  uint64
+	ImmDirs   uint64
+	ImmOldest int64
+	ImmNewest int64
+	RecSize   uint64
+	RecFiles  uint64
+	RecDirs   uint64
+	RecOldest int64
+	RecNewest int64
+	ImmShared uint64
+	RecShared uint64
+	ImmBlocks uint64
+	RecBlocks uint64
+	Uid       uint32
+	Gid       uint32
+	Children  []*DirInfo
+	Parent    *DirInfo
+	// children still being walked plus one for the listing itself
+	pending int32
+}
+
+func NewDirInfo(name string) *DirInfo {
+	return &DirInfo{
+		Name:      name,
+		ImmOldest: math.MaxInt64,
+		ImmNewest: math.MinInt64,
+		RecOldest: math.MaxInt64,
+		RecNewest: math.MinInt64,
+		Uid:       NullUserId,
+		Gid:       NullUserId,
+		Children:  make([]*DirInfo, 0),
+		pending:   1,
+	}
+}
+
+// ImmUsage is the immediate bytes used by files in the directory - either
+// apparent or allocated
+func (dir *DirInfo) ImmUsage(allocated bool) uint64 {
+	if allocated {
+		return dir.ImmBlocks
+	}
+	return dir.ImmSize
+}
+
+// RecUsage is the recursive version of ImmUsage
+func (dir *DirInfo) RecUsage(allocated bool) uint64 {
+	if allocated {
+		return dir.RecBlocks
+	}
+	return dir.RecSize
+}
+
+// Find walks down from dir by path components - nil when path is not in the tree
+func (dir *DirInfo) Find(path string) *DirInfo {
+	rel, err := filepath.Rel(dir.Name, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	if rel == "." {
+		return dir
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		want := filepath.Join(dir.Name, part)
+		i := slices.IndexFunc(dir.Children, func(d *DirInfo) bool { return d.Name == want })
+		if i < 0 {
+			return nil
+		}
+		dir = dir.Children[i]
+	}
+	return dir
+}
+
+// treePerk rolls every directory up into its parent bottom up
+func treePerk(dir *DirInfo) {
+	for _, child := range dir.Children {
+		treePerk(child)
+	}
+	perkDir(dir)
+}
+
+// perkDir is one level of treePerk - children must already be complete
+func perkDir(dir *DirInfo) {
+	for _, child := range dir.Children {
+		dir.RecSize += child.RecSize
+		dir.RecFiles += child.RecFiles
+		dir.RecDirs += child.RecDirs
+		dir.RecShared += child.RecShared
+		dir.RecBlocks += child.RecBlocks
+		dir.RecNewest = max(dir.RecNewest, child.RecNewest)
+		dir.RecOldest = min(dir.RecOldest, child.RecOldest)
+	}
+}
+
+// rollsUp is true when directories are rolled up as they complete during the
+// walk instead of by treePerk after it
+func (s *Scanner) rollsUp() bool {
+	return s.opts.Stream != nil || s.opts.Hooks.OnDirDone != nil
+}
+
+// walkDone is called once when the directory listing is done and once per
+// child subtree completing.  Whoever drops pending to zero finishes the
+// directory, so there is no locking on the DirInfo fields themselves.
+func (s *Scanner) walkDone(dir *DirInfo, depth int) {
+	if !s.rollsUp() {
+		return
+	}
+	if atomic.AddInt32(&dir.pending, -1) != 0 {
+		return
+	}
+	perkDir(dir)
+	if s.opts.Hooks.OnDirDone != nil {
+		s.opts.Hooks.OnDirDone(dir, depth)
+	}
+	if s.opts.Stream != nil {
+		s.opts.Stream(dir, depth)
+		dir.Children = nil
+	}
+	if dir.Parent != nil {
+		s.walkDone(dir.Parent, depth-1)
+	}
+}
@@ -0,0 +1,106 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTreePerk(t *testing.T) {
+	root := NewDirInfo("/r")
+	a := NewDirInfo("/r/a")
+	b := NewDirInfo("/r/a/b")
+	empty := NewDirInfo("/r/empty")
+	root.Children = []*DirInfo{a, empty}
+	a.Children = []*DirInfo{b}
+	// the walker counts immediate values into Rec as it goes
+	for _, d := range []struct {
+		dir            *DirInfo
+		size, files    uint64
+		dirs           uint64
+		oldest, newest int64
+	}{
+		{root, 1, 1, 2, 50, 50},
+		{a, 10, 2, 1, 20, 30},
+		{b, 100, 3, 0, 10, 40},
+	} {
+		d.dir.ImmSize, d.dir.RecSize = d.size, d.size
+		d.dir.ImmBlocks, d.dir.RecBlocks = d.size*2, d.size*2
+		d.dir.ImmFiles, d.dir.RecFiles = d.files, d.files
+		d.dir.ImmDirs, d.dir.RecDirs = d.dirs, d.dirs
+		d.dir.ImmOldest, d.dir.RecOldest = d.oldest, d.oldest
+		d.dir.ImmNewest, d.dir.RecNewest = d.newest, d.newest
+	}
+	treePerk(root)
+
+	for _, c := range []struct {
+		dir                       *DirInfo
+		size, blocks, files, dirs uint64
+		oldest, newest            int64
+	}{
+		{root, 111, 222, 6, 3, 10, 50},
+		{a, 110, 220, 5, 1, 10, 40},
+		{b, 100, 200, 3, 0, 10, 40},
+		{empty, 0, 0, 0, 0, math.MaxInt64, math.MinInt64},
+	} {
+		d := c.dir
+		if d.RecSize != c.size || d.RecBlocks != c.blocks || d.RecFiles != c.files || d.RecDirs != c.dirs ||
+			d.RecOldest != c.oldest || d.RecNewest != c.newest {
+			t.Errorf("%s: got size %d blocks %d files %d dirs %d oldest %d newest %d", d.Name,
+				d.RecSize, d.RecBlocks, d.RecFiles, d.RecDirs, d.RecOldest, d.RecNewest)
+		}
+	}
+	if root.ImmSize != 1 || a.ImmFiles != 2 {
+		t.Errorf("immediate values changed by perk")
+	}
+}
+
+func TestScanAggregation(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		fsys := NewMemFS()
+		fsys.Add("/r/top", MemFile{Size: 1, Allocated: 4096, ModTime: time.Unix(500, 0)})
+		fsys.Add("/r/a/x", MemFile{Size: 10, Allocated: 4096, ModTime: time.Unix(300, 0)})
+		fsys.Add("/r/a/y", MemFile{Size: 20, Allocated: 4096, ModTime: time.Unix(100, 0)})
+		fsys.Add("/r/a/b/c/z", MemFile{Size: 300, Allocated: 8192, ModTime: time.Unix(900, 0)})
+		fsys.Add("/r/d", MemFile{Mode: fs.ModeDir})
+
+		opts := Options{FS: fsys, Threads: 3, TopN: 10}
+		var streamed int
+		if streaming {
+			opts.Stream = func(dir *DirInfo, depth int) { streamed++ }
+		}
+		res, err := New(opts).Scan(context.Background(), "/r")
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := res.Root
+		if root.RecSize != 331 || root.RecBlocks != 20480 || root.RecFiles != 4 || root.RecDirs != 4 ||
+			root.RecOldest != 100 || root.RecNewest != 900 {
+			t.Errorf("streaming %v root %+v", streaming, *root)
+		}
+		if root.ImmSize != 1 || root.ImmFiles != 1 || root.ImmDirs != 2 || root.ImmOldest != 500 {
+			t.Errorf("streaming %v root immediate %+v", streaming, *root)
+		}
+		if res.Totals != (Totals{Size: 331, Allocated: 20480, Files: 4, Dirs: 4}) {
+			t.Errorf("streaming %v totals %+v", streaming, res.Totals)
+		}
+		if streaming {
+			if streamed != 5 || len(root.Children) != 0 {
+				t.Errorf("streamed %d dirs with %d children left", streamed, len(root.Children))
+			}
+			continue
+		}
+		a := root.Find("/r/a")
+		if a == nil || a.RecSize != 330 || a.ImmSize != 30 || a.ImmOldest != 100 || a.ImmNewest != 300 || a.RecNewest != 900 {
+			t.Errorf("a %+v", a)
+		}
+		if c := root.Find("/r/a/b/c"); c == nil || c.RecSize != 300 || c.Parent != root.Find("/r/a/b") {
+			t.Errorf("c %+v", c)
+		}
+		if root.Find("/r/nope") != nil || root.Find("/elsewhere") != nil {
+			t.Error("Find of a missing path")
+		}
+	}
+}
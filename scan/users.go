@@ -0,0 +1,94 @@
+package scan
+
+import (
+	"cmp"
+	"slices"
+)
+
+// NullUserId is the owner of a directory that could not be stat'ed
+const NullUserId = ^uint32(0)
+
+// UserStats is what one user id owns - Blocks is the allocated bytes
+type UserStats struct {
+	Uid    uint32
+	Size   uint64
+	Blocks uint64
+	Files  uint64
+	Dirs   uint64
+}
+
+// Usage is the apparent or allocated bytes
+func (user *UserStats) Usage(allocated bool) uint64 {
+	if allocated {
+		return user.Blocks
+	}
+	return user.Size
+}
+
+// SortUsers orders by usage and then by file and directory count, largest first
+func SortUsers(list []UserStats, allocated bool) {
+	slices.SortFunc(list, func(i, j UserStats) int {
+		if c := cmp.Compare(j.Usage(allocated), i.Usage(allocated)); c != 0 {
+			return c
+		}
+		return cmp.Compare(j.Files+j.Dirs, i.Files+i.Dirs)
+	})
+}
+
+// userTally accumulates one user at a time in a walker so the shared map is
+// only touched when the owner changes or the directory is done
+type userTally struct {
+	UserStats
+	s *Scanner
+}
+
+func (user *userTally) clear(uid uint32) {
+	user.UserStats = UserStats{Uid: uid}
+}
+
+func (user *userTally) switchUser(uid uint32) {
+	if user.Uid != NullUserId {
+		user.s.userSwitches.Add(1)
+	}
+	user.flush()
+	user.clear(uid)
+}
+
+func (user *userTally) addDir(uid uint32) {
+	if user.Uid == NullUserId {
+		user.Uid = uid
+	}
+	if uid != user.Uid {
+		user.switchUser(uid)
+	}
+	user.Dirs += 1
+}
+
+func (user *userTally) addFile(uid uint32, size uint64, blocks uint64) {
+	if user.Uid == NullUserId {
+		user.Uid = uid
+	}
+	if uid != user.Uid {
+		user.switchUser(uid)
+	}
+	user.Files += 1
+	user.Size += size
+	user.Blocks += blocks
+}
+
+func (user *userTally) flush() {
+	if user.Uid == NullUserId {
+		return
+	}
+	info := user.UserStats
+	user.s.users.Compute(info.Uid, func(oldValue UserStats, loaded bool) (newValue UserStats, delete bool) {
+		if !loaded {
+			return info, false
+		}
+		oldValue.Dirs += info.Dirs
+		oldValue.Files += info.Files
+		oldValue.Size += info.Size
+		oldValue.Blocks += info.Blocks
+		return oldValue, false
+	})
+}
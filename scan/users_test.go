@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestUserStats(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.Add("/r/d", MemFile{Mode: fs.ModeDir, Uid: 2})
+	fsys.Add("/r/d/g", MemFile{Size: 5, Allocated: 4096, Uid: 2})
+	// owners alternate inside one directory
+	fsys.Add("/r/f1", MemFile{Size: 10, Allocated: 4096, Uid: 1})
+	fsys.Add("/r/f2", MemFile{Size: 20, Allocated: 4096, Uid: 2})
+	fsys.Add("/r/f3", MemFile{Size: 30, Allocated: 4096, Uid: 1})
+
+	res, err := New(Options{FS: fsys, TopN: 5}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UserStats{
+		{Uid: 1, Size: 40, Blocks: 8192, Files: 2},
+		{Uid: 2, Size: 25, Blocks: 8192, Files: 2, Dirs: 1},
+	}
+	if got := res.UserList(); !slices.Equal(got, want) {
+		t.Errorf("users got %+v want %+v", got, want)
+	}
+	if res.UserSwitches != 3 {
+		t.Errorf("user switches %d", res.UserSwitches)
+	}
+	if res.Root.Uid != 0 || res.Root.Find("/r/d").Uid != 2 {
+		t.Errorf("directory owners %d %d", res.Root.Uid, res.Root.Find("/r/d").Uid)
+	}
+
+	// by allocated bytes both users tie so file and directory count decides
+	res.Options.Allocated = true
+	if got := res.UserList(); got[0].Uid != 2 {
+		t.Errorf("allocated order %+v", got)
+	}
+}
+
+func TestSortUsers(t *testing.T) {
+	list := []UserStats{
+		{Uid: 1, Size: 10, Files: 1},
+		{Uid: 2, Size: 30, Blocks: 1},
+		{Uid: 3, Size: 10, Files: 5},
+		{Uid: 4, Size: 20, Blocks: 50},
+	}
+	SortUsers(list, false)
+	var order []uint32
+	for _, u := range list {
+		order = append(order, u.Uid)
+	}
+	if !slices.Equal(order, []uint32{2, 4, 3, 1}) {
+		t.Errorf("apparent order %v", order)
+	}
+	SortUsers(list, true)
+	if list[0].Uid != 4 || list[1].Uid != 2 {
+		t.Errorf("allocated order %+v", list)
+	}
+}
@@ -0,0 +1,61 @@
+package scan
+
+import "slices"
+
+// UserTop is where one user's bytes are - each list is at most Options.TopN
+// long like the global ones
+type UserTop struct {
+	// LargestFiles are the user's largest files
+	LargestFiles []PathSize
+	// DirsByOwnedSize are directories by the bytes of the user's files
+	// immediately in them
+	DirsByOwnedSize []PathSize
+}
+
+type userTopLists struct {
+	files *topFiles
+	dirs  *topFiles
+}
+
+// tracksUserTop is whether uid gets per user top-N lists
+func (s *Scanner) tracksUserTop(uid uint32) bool {
+	return s.opts.UserTops && (len(s.opts.UserTopsFor) == 0 || slices.Contains(s.opts.UserTopsFor, uid))
+}
+
+func (s *Scanner) userTop(uid uint32) *userTopLists {
+	lists, _ := s.userTops.LoadOrCompute(uid, func() *userTopLists {
+		return &userTopLists{newTopFiles(s.opts.TopN), newTopFiles(s.opts.TopN)}
+	})
+	return lists
+}
+
+// ownedTally adds up the bytes each user owns immediately in one directory so
+// the user's directory list is only offered the directory once it is done
+type ownedTally struct {
+	s     *Scanner
+	dir   *DirInfo
+	owned map[uint32]int64
+}
+
+func (t *ownedTally) addFile(uid uint32, usage int64, path *string) {
+	if !t.s.tracksUserTop(uid) {
+		return
+	}
+	t.s.userTop(uid).files.setMaxFile(usage, path)
+	t.owned[uid] += usage
+}
+
+func (t *ownedTally) flush() {
+	for uid, usage := range t.owned {
+		t.s.userTop(uid).dirs.setMaxFile(usage, &t.dir.Name)
+	}
+}
+
+// userTopResults fills Result.UserTops
+func (s *Scanner) userTopResults(res *Result) {
+	res.UserTops = map[uint32]UserTop{}
+	s.userTops.Range(func(uid uint32, lists *userTopLists) bool {
+		res.UserTops[uid] = UserTop{LargestFiles: lists.files.list(), DirsByOwnedSize: lists.dirs.list()}
+		return true
+	})
+}
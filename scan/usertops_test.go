@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"context"
+	"testing"
+)
+
+func userTopTree() *MemFS {
+	fsys := NewMemFS()
+	fsys.Add("/r/a/big", MemFile{Size: 9000, Allocated: 12288, Uid: 1})
+	fsys.Add("/r/a/small", MemFile{Size: 10, Allocated: 4096, Uid: 1})
+	fsys.Add("/r/a/theirs", MemFile{Size: 50000, Uid: 2})
+	fsys.Add("/r/b/c/one", MemFile{Size: 400, Uid: 1})
+	fsys.Add("/r/b/c/two", MemFile{Size: 500, Uid: 1})
+	fsys.Add("/r/b/x", MemFile{Size: 7, Uid: 2})
+	fsys.Add("/r/top", MemFile{Size: 30, Uid: 1})
+	return fsys
+}
+
+func TestUserTops(t *testing.T) {
+	res, err := New(Options{FS: userTopTree(), Threads: 2, TopN: 3, UserTops: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.UserTops) != 2 {
+		t.Fatalf("user tops %+v", res.UserTops)
+	}
+	u1 := res.UserTops[1]
+	if got := paths(u1.LargestFiles); len(got) != 3 || got[0] != "/r/a/big=9000" || got[2] != "/r/b/c/one=400" {
+		t.Errorf("largest files of uid 1 %v", got)
+	}
+	if got := paths(u1.DirsByOwnedSize); len(got) != 3 || got[0] != "/r/a=9010" || got[1] != "/r/b/c=900" || got[2] != "/r=30" {
+		t.Errorf("dirs of uid 1 %v", got)
+	}
+	if got := paths(res.UserTops[2].DirsByOwnedSize); len(got) != 2 || got[0] != "/r/a=50000" || got[1] != "/r/b=7" {
+		t.Errorf("dirs of uid 2 %v", got)
+	}
+
+	res, err = New(Options{FS: userTopTree(), Threads: 2, TopN: 3, UserTops: true, Allocated: true}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(res.UserTops[1].DirsByOwnedSize); len(got) != 1 || got[0] != "/r/a=16384" {
+		t.Errorf("allocated dirs of uid 1 %v", got)
+	}
+}
+
+func TestUserTopsFor(t *testing.T) {
+	res, err := New(Options{FS: userTopTree(), Threads: 2, TopN: 3, UserTops: true, UserTopsFor: []uint32{2}}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.UserTops[1]; ok || len(res.UserTops) != 1 {
+		t.Errorf("user tops %+v", res.UserTops)
+	}
+	if got := paths(res.UserTops[2].LargestFiles); len(got) != 2 || got[0] != "/r/a/theirs=50000" {
+		t.Errorf("largest files of uid 2 %v", got)
+	}
+	res, err = New(Options{FS: userTopTree(), TopN: 3}).Scan(context.Background(), "/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UserTops != nil {
+		t.Errorf("user tops without the option %+v", res.UserTops)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// scanServer rescans on a schedule and serves the latest completed scan.  One
+// scanner is reused so only one scan runs at a time; the published result is
+// never touched again once swapped in.
+type scanServer struct {
+	root     string
+	interval time.Duration
+	scanner  *scan.Scanner
+
+	mtx       sync.RWMutex
+	latest    *scanResult
+	scanning  bool
+	scanStart time.Time
+	scanCount uint64
+	nextScan  time.Time
+	lastError string
+}
+
+type scanResult struct {
+	res *scan.Result
+	doc *jsonDocument
+}
+
+type apiStatus struct {
+	Root      string        `json:"root"`
+	Scanning  bool          `json:"scanning"`
+	ScanCount uint64        `json:"scan_count"`
+	NextScan  *time.Time    `json:"next_scan,omitempty"`
+	LastError string        `json:"last_error,omitempty"`
+	Progress  *apiProgress  `json:"progress,omitempty"`
+	Latest    *jsonScanMeta `json:"latest,omitempty"`
+	Totals    *jsonTotals   `json:"totals,omitempty"`
+	Errors    *jsonErrors   `json:"errors,omitempty"`
+}
+
+// apiProgress is the live counters of the scan in flight
+type apiProgress struct {
+	StartTime      time.Time `json:"start_time"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	Size           uint64    `json:"size"`
+	Files          uint64    `json:"files"`
+	Dirs           uint64    `json:"dirs"`
+}
+
+type apiTree struct {
+	jsonDirInfo
+	Children []*jsonDirInfo `json:"children"`
+}
+
+func newScanServer(root string, opts scan.Options, interval time.Duration) *scanServer {
+	return &scanServer{root: root, interval: interval, scanner: scan.New(opts)}
+}
+
+// rescan runs one full scan and publishes it
+func (s *scanServer) rescan(ctx context.Context) error {
+	start := time.Now()
+	s.mtx.Lock()
+	s.scanning = true
+	s.scanStart = start
+	s.mtx.Unlock()
+
+	res, err := s.scanner.Scan(ctx, s.root)
+	var result *scanResult
+	if err == nil {
+		result = &scanResult{res: res, doc: buildJsonDocument(res, false)}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.scanning = false
+	s.scanCount++
+	if err != nil {
+		s.lastError = err.Error()
+		return err
+	}
+	s.lastError = ""
+	s.latest = result
+	return nil
+}
+
+// run scans now and then every interval until ctx is done
+func (s *scanServer) run(ctx context.Context) {
+	for {
+		if err := s.rescan(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "scan failed:", err)
+		}
+		s.mtx.Lock()
+		s.nextScan = time.Now().Add(s.interval)
+		s.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *scanServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/status", s.handleStatus)
+	mux.HandleFunc("GET /api/v1/tree", s.handleTree)
+	mux.HandleFunc("GET /api/v1/top/{report}", s.handleTop)
+	mux.HandleFunc("GET /api/v1/users", s.handleUsers)
+	mux.HandleFunc("GET /metrics", liveMetricsHandler(s.root, s.scanner))
+	return mux
+}
+
+func writeApiJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, msg string) {
+	writeApiJson(w, status, map[string]string{"error": msg})
+}
+
+// current returns the latest scan or writes a 503 when there is none yet
+func (s *scanServer) current(w http.ResponseWriter) *scanResult {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.latest == nil {
+		writeApiError(w, http.StatusServiceUnavailable, "no scan completed yet")
+	}
+	return s.latest
+}
+
+func (s *scanServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mtx.RLock()
+	status := apiStatus{Root: s.root, Scanning: s.scanning, ScanCount: s.scanCount, LastError: s.lastError}
+	if !s.nextScan.IsZero() {
+		next := s.nextScan
+		status.NextScan = &next
+	}
+	if s.scanning {
+		status.Progress = &apiProgress{
+			StartTime:      s.scanStart,
+			ElapsedSeconds: time.Since(s.scanStart).Seconds(),
+			Size:           uint64(s.scanner.Progress.Size.Get()),
+			Files:          uint64(s.scanner.Progress.Files.Get()),
+			Dirs:           uint64(s.scanner.Progress.Dirs.Get()),
+		}
+	}
+	if s.latest != nil {
+		status.Latest = &s.latest.doc.Scan
+		status.Totals = &s.latest.doc.Totals
+		status.Errors = &s.latest.doc.Errors
+	}
+	s.mtx.RUnlock()
+	writeApiJson(w, http.StatusOK, status)
+}
+
+// handleTree serves one directory and its immediate children by size, the
+// path defaults to the scan root
+func (s *scanServer) handleTree(w http.ResponseWriter, r *http.Request) {
+	latest := s.current(w)
+	if latest == nil {
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = latest.res.Root.Name
+	}
+	dir := latest.res.Root.Find(path)
+	if dir == nil {
+		writeApiError(w, http.StatusNotFound, "path not in scanned tree: "+path)
+		return
+	}
+	children := slices.Clone(dir.Children)
+	slices.SortFunc(children, cmpBrowse(browseBySize))
+	tree := apiTree{jsonDirInfo: *jsonDirNode(dir), Children: make([]*jsonDirInfo, 0, len(children))}
+	for _, child := range children {
+		tree.Children = append(tree.Children, jsonDirNode(child))
+	}
+	writeApiJson(w, http.StatusOK, tree)
+}
+
+func (s *scanServer) handleTop(w http.ResponseWriter, r *http.Request) {
+	latest := s.current(w)
+	if latest == nil {
+		return
+	}
+	reports := map[string][]jsonPathSize{
+		"largest_files":      latest.doc.Reports.LargestFiles,
+		"dirs_by_imm_size":   latest.doc.Reports.DirsByImmSize,
+		"dirs_by_imm_files":  latest.doc.Reports.DirsByImmFiles,
+		"dirs_by_imm_dirs":   latest.doc.Reports.DirsByImmDirs,
+		"dirs_by_rec_size":   latest.doc.Reports.DirsByRecSize,
+		"dirs_by_rec_shared": latest.doc.Reports.DirsByRecShared,
+		"sparse_files":       latest.doc.Reports.SparseFiles,
+	}
+	list, ok := reports[r.PathValue("report")]
+	if !ok {
+		writeApiError(w, http.StatusNotFound, "unknown report: "+r.PathValue("report"))
+		return
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n >= 0 && n < len(list) {
+		list = list[:n]
+	}
+	writeApiJson(w, http.StatusOK, list)
+}
+
+func (s *scanServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	latest := s.current(w)
+	if latest == nil {
+		return
+	}
+	writeApiJson(w, http.StatusOK, latest.doc.Users)
+}
+
+// serve runs the scan loop and http server until ctx is done
+func (s *scanServer) serve(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.handler()}
+	go s.run(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// newTestServer serves scans of the tree under /r of fsys
+func newTestServer(t *testing.T, fsys *scan.MemFS) (*scanServer, *apiClient) {
+	t.Helper()
+	s := newScanServer("/r", scan.Options{FS: fsys, Threads: 4, TopN: 10}, 0)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return s, newApiClient(ts.URL)
+}
+
+func TestServerBeforeFirstScan(t *testing.T) {
+	_, c := newTestServer(t, scan.NewMemFS())
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ScanCount != 0 || status.Latest != nil {
+		t.Errorf("expected no scan yet, got %+v", status)
+	}
+	if _, err := c.Tree(""); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected 503 before first scan, got %v", err)
+	}
+}
+
+func TestServerEndpoints(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/a/big", scan.MemFile{Size: 5000})
+	fsys.Add("/r/a/b/small", scan.MemFile{Size: 100})
+	fsys.Add("/r/c/medium", scan.MemFile{Size: 2000})
+	fsys.Add("/r/top-level-f", scan.MemFile{Size: 10})
+	s, c := newTestServer(t, fsys)
+	if err := s.rescan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ScanCount != 1 || status.Scanning || status.Latest == nil || !status.Latest.Complete {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.Totals.Size != 7110 || status.Totals.Files != 4 || status.Totals.Dirs != 3 {
+		t.Errorf("unexpected totals %+v", *status.Totals)
+	}
+
+	tree, err := c.Tree("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Path != "/r" || tree.RecSize != 7110 || tree.ImmSize != 10 || len(tree.Children) != 2 {
+		t.Fatalf("unexpected root %+v with %d children", tree.jsonDirInfo, len(tree.Children))
+	}
+	if tree.Children[0].Path != "/r/a" || tree.Children[0].RecSize != 5100 {
+		t.Errorf("children not sorted by size: first is %+v", *tree.Children[0])
+	}
+
+	sub, err := c.Tree("/r/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.ImmSize != 5000 || sub.RecFiles != 2 || len(sub.Children) != 1 {
+		t.Errorf("unexpected subtree %+v", sub.jsonDirInfo)
+	}
+	if _, err := c.Tree("/r/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected 404 for missing path, got %v", err)
+	}
+
+	largest, err := c.Top("largest_files", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(largest) != 2 || largest[0].Value != 5000 || largest[1].Value != 2000 {
+		t.Errorf("unexpected largest files %+v", largest)
+	}
+	if _, err := c.Top("nope", 0); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected 404 for unknown report, got %v", err)
+	}
+
+	users, err := c.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size uint64
+	for _, u := range users {
+		size += u.Size
+	}
+	if size != 7110 {
+		t.Errorf("user sizes add up to %d", size)
+	}
+}
+
+func TestServerRescanReplacesResult(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/f", scan.MemFile{Size: 100})
+	s, c := newTestServer(t, fsys)
+	if err := s.rescan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fsys.Add("/r/g", scan.MemFile{Size: 50})
+	if err := s.rescan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ScanCount != 2 || status.Totals.Size != 150 || status.Totals.Files != 2 {
+		t.Errorf("rescan did not reset state: %+v", *status.Totals)
+	}
+}
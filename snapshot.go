@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// Snapshot layout - everything after the magic is uvarint/varint encoded:
+//
+//	magic "DU2SNAP\n", version
+//	header: root, start unix nanos, elapsed nanos, flags, size, alloc, files, dirs
+//	'D' records in preorder with children sorted by name so two snapshots can be
+//	    merged as streams: rel path, depth, imm size/blocks/files/dirs/old/new,
+//	    rec size/blocks/files/dirs/old/new/shared
+//	'U' records: uid, size, blocks, files, dirs
+//	'G' records: gid, size, blocks, files, dirs
+//	'E' end marker
+const snapshotMagic = "DU2SNAP\n"
+const snapshotVersion = 1
+
+const (
+	snapTagDir   = 'D'
+	snapTagUser  = 'U'
+	snapTagGroup = 'G'
+	snapTagEnd   = 'E'
+)
+
+const (
+	snapFlagAllocated = 1 << iota
+	snapFlagDedup
+	snapFlagIncomplete
+)
+
+type snapshotHeader struct {
+	version uint64
+	root    string
+	start   time.Time
+	elapsed time.Duration
+	flags   uint64
+	size    uint64
+	alloc   uint64
+	files   uint64
+	dirs    uint64
+}
+
+// snapDir is a DirInfo as read back - rel is relative to the root with "." for the root itself
+type snapDir struct {
+	rel   string
+	depth int
+	dir   scan.DirInfo
+}
+
+// cmpSnapPath orders paths the way the preorder walk writes them - the
+// separator sorts below every other byte so a/b comes before a-b
+func cmpSnapPath(a, b string) int {
+	// the root is written first and its rel path "." would not sort first
+	if a == "." || b == "." {
+		return cmpBool(a != ".", b != ".")
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		if ca == cb {
+			continue
+		}
+		if ca == filepath.Separator {
+			return -1
+		}
+		if cb == filepath.Separator {
+			return 1
+		}
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+	return len(a) - len(b)
+}
+
+type snapWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (s *snapWriter) uvarint(v uint64) {
+	if s.err == nil {
+		_, s.err = s.w.Write(s.buf[:binary.PutUvarint(s.buf[:], v)])
+	}
+}
+
+func (s *snapWriter) varint(v int64) {
+	if s.err == nil {
+		_, s.err = s.w.Write(s.buf[:binary.PutVarint(s.buf[:], v)])
+	}
+}
+
+func (s *snapWriter) str(v string) {
+	s.uvarint(uint64(len(v)))
+	if s.err == nil {
+		_, s.err = s.w.WriteString(v)
+	}
+}
+
+func (s *snapWriter) tag(t byte) {
+	if s.err == nil {
+		s.err = s.w.WriteByte(t)
+	}
+}
+
+func (s *snapWriter) dir(root string, dir *scan.DirInfo, depth int) {
+	rel, err := filepath.Rel(root, dir.Name)
+	if err != nil {
+		rel = dir.Name
+	}
+	s.tag(snapTagDir)
+	s.str(rel)
+	s.uvarint(uint64(depth))
+	s.uvarint(dir.ImmSize)
+	s.uvarint(dir.ImmBlocks)
+	s.uvarint(dir.ImmFiles)
+	s.uvarint(dir.ImmDirs)
+	s.varint(dir.ImmOldest)
+	s.varint(dir.ImmNewest)
+	s.uvarint(dir.RecSize)
+	s.uvarint(dir.RecBlocks)
+	s.uvarint(dir.RecFiles)
+	s.uvarint(dir.RecDirs)
+	s.varint(dir.RecOldest)
+	s.varint(dir.RecNewest)
+	s.uvarint(dir.RecShared)
+
+	// sorting here is what lets diff merge two snapshots without loading them
+	children := slices.Clone(dir.Children)
+	slices.SortFunc(children, func(a, b *scan.DirInfo) int { return cmpSnapPath(a.Name, b.Name) })
+	for _, child := range children {
+		s.dir(root, child, depth+1)
+	}
+}
+
+// saveSnapshot writes the scanned tree along with the user and group stats
+func saveSnapshot(fname string, res *scan.Result) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	var flags uint64
+	if res.Options.Allocated {
+		flags |= snapFlagAllocated
+	}
+	if res.Options.DedupHardLinks {
+		flags |= snapFlagDedup
+	}
+	if res.Incomplete != nil {
+		flags |= snapFlagIncomplete
+	}
+
+	s := &snapWriter{w: bufio.NewWriterSize(f, 256*1024)}
+	_, s.err = s.w.WriteString(snapshotMagic)
+	s.uvarint(snapshotVersion)
+	s.str(res.Root.Name)
+	s.varint(res.Start.UnixNano())
+	s.varint(int64(res.Elapsed))
+	s.uvarint(flags)
+	s.uvarint(res.Totals.Size)
+	s.uvarint(res.Totals.Allocated)
+	s.uvarint(res.Totals.Files)
+	s.uvarint(res.Totals.Dirs)
+
+	s.dir(res.Root.Name, res.Root, 0)
+
+	for _, uid := range slices.Sorted(maps.Keys(res.Users)) {
+		value := res.Users[uid]
+		s.tag(snapTagUser)
+		s.uvarint(uint64(value.Uid))
+		s.uvarint(value.Size)
+		s.uvarint(value.Blocks)
+		s.uvarint(value.Files)
+		s.uvarint(value.Dirs)
+	}
+	for _, gid := range slices.Sorted(maps.Keys(res.Groups)) {
+		value := res.Groups[gid]
+		s.tag(snapTagGroup)
+		s.uvarint(uint64(value.Gid))
+		s.uvarint(value.Size)
+		s.uvarint(value.Blocks)
+		s.uvarint(value.Files)
+		s.uvarint(value.Dirs)
+	}
+	s.tag(snapTagEnd)
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	if err := f.Close(); s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// snapReader streams a snapshot back one record at a time
+type snapReader struct {
+	r      *bufio.Reader
+	f      *os.File
+	header snapshotHeader
+	err    error
+}
+
+func (s *snapReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	var v uint64
+	v, s.err = binary.ReadUvarint(s.r)
+	return v
+}
+
+func (s *snapReader) varint() int64 {
+	if s.err != nil {
+		return 0
+	}
+	var v int64
+	v, s.err = binary.ReadVarint(s.r)
+	return v
+}
+
+func (s *snapReader) str() string {
+	n := s.uvarint()
+	if s.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	_, s.err = io.ReadFull(s.r, b)
+	return string(b)
+}
+
+func openSnapshot(fname string) (*snapReader, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapReader{r: bufio.NewReaderSize(f, 256*1024), f: f}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(s.r, magic); err != nil || string(magic) != snapshotMagic {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a du2go snapshot", fname)
+	}
+	h := &s.header
+	h.version = s.uvarint()
+	if s.err == nil && h.version != snapshotVersion {
+		f.Close()
+		return nil, fmt.Errorf("%s has snapshot version %d, only version %d is supported", fname, h.version, snapshotVersion)
+	}
+	h.root = s.str()
+	h.start = time.Unix(0, s.varint())
+	h.elapsed = time.Duration(s.varint())
+	h.flags = s.uvarint()
+	h.size = s.uvarint()
+	h.alloc = s.uvarint()
+	h.files = s.uvarint()
+	h.dirs = s.uvarint()
+	if s.err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading snapshot header of %s: %w", fname, s.err)
+	}
+	return s, nil
+}
+
+func (s *snapReader) Close() error {
+	return s.f.Close()
+}
+
+// snapOwners are the user and group records of a snapshot
+type snapOwners struct {
+	users  map[uint32]scan.UserStats
+	groups map[uint32]scan.GroupStats
+}
+
+func newSnapOwners() *snapOwners {
+	return &snapOwners{users: map[uint32]scan.UserStats{}, groups: map[uint32]scan.GroupStats{}}
+}
+
+// next returns the next directory or nil at the end of the directories.  User
+// and group records that follow are collected into owners.
+func (s *snapReader) next(owners *snapOwners) (*snapDir, error) {
+	for {
+		t, err := s.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t {
+		case snapTagDir:
+			d := &snapDir{}
+			d.rel = s.str()
+			d.depth = int(s.uvarint())
+			d.dir.Name = filepath.Join(s.header.root, d.rel)
+			d.dir.ImmSize = s.uvarint()
+			d.dir.ImmBlocks = s.uvarint()
+			d.dir.ImmFiles = s.uvarint()
+			d.dir.ImmDirs = s.uvarint()
+			d.dir.ImmOldest = s.varint()
+			d.dir.ImmNewest = s.varint()
+			d.dir.RecSize = s.uvarint()
+			d.dir.RecBlocks = s.uvarint()
+			d.dir.RecFiles = s.uvarint()
+			d.dir.RecDirs = s.uvarint()
+			d.dir.RecOldest = s.varint()
+			d.dir.RecNewest = s.varint()
+			d.dir.RecShared = s.uvarint()
+			return d, s.err
+		case snapTagUser:
+			u := scan.UserStats{}
+			u.Uid = uint32(s.uvarint())
+			u.Size = s.uvarint()
+			u.Blocks = s.uvarint()
+			u.Files = s.uvarint()
+			u.Dirs = s.uvarint()
+			if s.err != nil {
+				return nil, s.err
+			}
+			owners.users[u.Uid] = u
+		case snapTagGroup:
+			g := scan.GroupStats{}
+			g.Gid = uint32(s.uvarint())
+			g.Size = s.uvarint()
+			g.Blocks = s.uvarint()
+			g.Files = s.uvarint()
+			g.Dirs = s.uvarint()
+			if s.err != nil {
+				return nil, s.err
+			}
+			owners.groups[g.Gid] = g
+		case snapTagEnd:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("corrupt snapshot - unknown record tag %q", t)
+		}
+	}
+}
+
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+func writeSnap(t *testing.T, res *scan.Result) *snapReader {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "du.snap")
+	if err := saveSnapshot(fname, res); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSnapshot(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// what is saved comes back the same, directories in sorted preorder
+func TestSnapshotRoundTrip(t *testing.T) {
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/a-b/f", scan.MemFile{Size: 5, Allocated: 4096, Uid: 2, Gid: 20})
+	fsys.Add("/r/a/b/g", scan.MemFile{Size: 7, Allocated: 4096, Uid: 1, Gid: 10})
+	fsys.Add("/r/a/h", scan.MemFile{Size: 11, Allocated: 4096, Uid: 1, Gid: 20})
+	res := scanMemFS(t, fsys, scan.Options{})
+	s := writeSnap(t, res)
+
+	h := s.header
+	if h.version != snapshotVersion || h.root != "/r" || !h.start.Equal(res.Start) || h.elapsed != res.Elapsed ||
+		h.size != res.Totals.Size || h.alloc != res.Totals.Allocated || h.files != res.Totals.Files ||
+		h.dirs != res.Totals.Dirs || h.flags != 0 {
+		t.Errorf("header %+v", h)
+	}
+
+	owners := newSnapOwners()
+	var rels []string
+	for {
+		d, err := s.next(owners)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d == nil {
+			break
+		}
+		rels = append(rels, d.rel)
+		want := res.Root.Find(d.dir.Name)
+		if want == nil {
+			t.Fatalf("%s not in the scan", d.dir.Name)
+		}
+		if d.dir.RecSize != want.RecSize || d.dir.RecFiles != want.RecFiles || d.dir.ImmSize != want.ImmSize ||
+			d.dir.RecBlocks != want.RecBlocks || d.dir.RecNewest != want.RecNewest {
+			t.Errorf("%s: %+v want %+v", d.rel, d.dir, *want)
+		}
+	}
+	wantRels := []string{".", "a", filepath.Join("a", "b"), "a-b"}
+	if len(rels) != len(wantRels) {
+		t.Fatalf("dirs %v want %v", rels, wantRels)
+	}
+	for i := range rels {
+		if rels[i] != wantRels[i] {
+			t.Errorf("dirs %v want %v", rels, wantRels)
+			break
+		}
+	}
+	if len(owners.users) != len(res.Users) || len(owners.groups) != len(res.Groups) {
+		t.Fatalf("users %v groups %v", owners.users, owners.groups)
+	}
+	for uid, u := range res.Users {
+		if owners.users[uid] != u {
+			t.Errorf("user %d %+v want %+v", uid, owners.users[uid], u)
+		}
+	}
+	for gid, g := range res.Groups {
+		if owners.groups[gid] != g {
+			t.Errorf("group %d %+v want %+v", gid, owners.groups[gid], g)
+		}
+	}
+}
+
+func TestCmpSnapPath(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{".", "a", -1},
+		{"a", ".", 1},
+		{"a", "a", 0},
+		{"a/b", "a-b", -1},
+		{"a/b/c", "a-b", -1},
+		{"a-b", "a/b", 1},
+		{"a", "a/b", -1},
+		{"ab", "a/b", 1},
+	} {
+		a, b := filepath.FromSlash(c.a), filepath.FromSlash(c.b)
+		if got := cmpSnapPath(a, b); (got > 0) != (c.want > 0) || (got < 0) != (c.want < 0) {
+			t.Errorf("cmpSnapPath(%q, %q) = %d want %d", a, b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// with streaming on (-format ndjson) every directory is written out as soon
+// as its subtree is complete - see scan.Options.Stream
+var streamMtx sync.Mutex
+var streamOut *bufio.Writer
+
+type ndjsonRecord struct {
+	Depth int `json:"depth"`
+	*jsonDirInfo
+}
+
+func startStream(w io.Writer) {
+	streamOut = bufio.NewWriterSize(w, 256*1024)
+}
+
+func flushStream() error {
+	streamMtx.Lock()
+	defer streamMtx.Unlock()
+	return streamOut.Flush()
+}
+
+func writeStreamRecord(dir *scan.DirInfo, depth int) {
+	line, err := json.Marshal(ndjsonRecord{Depth: depth, jsonDirInfo: jsonDirNode(dir)})
+	if err != nil {
+		panic(err) // only plain numbers and strings in here
+	}
+	streamMtx.Lock()
+	defer streamMtx.Unlock()
+	streamOut.Write(line)
+	streamOut.WriteByte('\n')
+}
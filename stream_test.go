@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// every directory is one line written after all of its subtree
+func TestStreamRecords(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fsys := scan.NewMemFS()
+	fsys.Add("/r/top", scan.MemFile{Size: 1, ModTime: now})
+	fsys.Add("/r/a/f", scan.MemFile{Size: 10, ModTime: now.Add(-time.Hour)})
+	fsys.Add("/r/a/b/g", scan.MemFile{Size: 100, ModTime: now.Add(-2 * time.Hour)})
+	fsys.Add("/r/a/b/c/h", scan.MemFile{Size: 1000, ModTime: now.Add(-3 * time.Hour)})
+	fsys.Add("/r/a/b/e/i", scan.MemFile{Size: 10000, ModTime: now.Add(-4 * time.Hour)})
+	fsys.Add("/r/d/j", scan.MemFile{Size: 100000, ModTime: now.Add(-5 * time.Hour)})
+	fsys.Add("/r/x/y/k", scan.MemFile{Size: 1000000, ModTime: now.Add(-6 * time.Hour)})
+
+	var buf bytes.Buffer
+	startStream(&buf)
+	res := scanMemFS(t, fsys, scan.Options{Threads: 4, Stream: writeStreamRecord})
+	if err := flushStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	type record struct {
+		Depth     int    `json:"depth"`
+		Path      string `json:"path"`
+		ImmSize   uint64 `json:"imm_size"`
+		ImmFiles  uint64 `json:"imm_files"`
+		ImmDirs   uint64 `json:"imm_dirs"`
+		ImmOldest *int64 `json:"imm_oldest"`
+		RecSize   uint64 `json:"rec_size"`
+		RecFiles  uint64 `json:"rec_files"`
+		RecDirs   uint64 `json:"rec_dirs"`
+		RecOldest *int64 `json:"rec_oldest"`
+		RecNewest *int64 `json:"rec_newest"`
+	}
+	seen := map[string]record{}
+	var order []string
+	lines := bufio.NewScanner(&buf)
+	for lines.Scan() {
+		var r record
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %v", lines.Text(), err)
+		}
+		if _, ok := seen[r.Path]; ok {
+			t.Errorf("%s written twice", r.Path)
+		}
+		for p := range seen {
+			if strings.HasPrefix(r.Path, p+"/") {
+				t.Errorf("%s written after its parent %s", r.Path, p)
+			}
+		}
+		if want := strings.Count(r.Path, "/") - 1; r.Depth != want {
+			t.Errorf("%s depth %d want %d", r.Path, r.Depth, want)
+		}
+		seen[r.Path] = r
+		order = append(order, r.Path)
+	}
+	if len(order) != 8 || order[len(order)-1] != "/r" {
+		t.Fatalf("records %v", order)
+	}
+
+	ab := seen["/r/a/b"]
+	if ab.ImmSize != 100 || ab.ImmFiles != 1 || ab.ImmDirs != 2 || ab.RecSize != 11100 || ab.RecFiles != 3 || ab.RecDirs != 2 {
+		t.Errorf("/r/a/b %+v", ab)
+	}
+	if ab.RecOldest == nil || *ab.RecOldest != now.Add(-4*time.Hour).Unix() || *ab.RecNewest != now.Add(-2*time.Hour).Unix() {
+		t.Errorf("/r/a/b times %v %v", ab.RecOldest, ab.RecNewest)
+	}
+	root := seen["/r"]
+	if root.ImmSize != 1 || root.RecSize != 1111111 || root.RecFiles != 7 || root.RecDirs != 7 || root.RecSize != res.Totals.Size {
+		t.Errorf("/r %+v", root)
+	}
+	// a directory with only directories in it has no times of its own
+	if x := seen["/r/x"]; x.ImmOldest != nil || x.RecOldest == nil || *x.RecOldest != now.Add(-6*time.Hour).Unix() {
+		t.Errorf("/r/x %+v", x)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sflanaga/du2go/scan"
+	"github.com/sflanaga/statticker"
+	"golang.org/x/term"
+)
+
+// sort orders for the browser - only the children of the directory on screen
+// are ever sorted so it stays quick on huge trees
+const (
+	browseBySize  = 's'
+	browseByFiles = 'f'
+	browseByDirs  = 'd'
+	browseByAge   = 'a'
+)
+
+type browser struct {
+	res    *scan.Result
+	root   *scan.DirInfo
+	cur    *scan.DirInfo
+	sortBy rune
+	rows   []*scan.DirInfo
+	sel    int
+	top    int
+	width  int
+	height int
+	start  time.Time
+	out    *bufio.Writer
+	status string
+	// cleanup waiting for a y to confirm
+	pending    *scan.Removal
+	pendingAct string
+	cleanLog   string
+	// largest files view - a list of files rather than directories
+	showLargest bool
+	largest     []scan.PathSize
+}
+
+func cmpBrowse(sortBy rune) func(a, b *scan.DirInfo) int {
+	return func(a, b *scan.DirInfo) int {
+		var c int
+		switch sortBy {
+		case browseByFiles:
+			c = cmpBool(a.RecFiles < b.RecFiles, a.RecFiles > b.RecFiles)
+		case browseByDirs:
+			c = cmpBool(a.RecDirs < b.RecDirs, a.RecDirs > b.RecDirs)
+		case browseByAge:
+			// oldest newest file first - the stalest trees float to the top
+			c = cmpBool(a.RecNewest > b.RecNewest, a.RecNewest < b.RecNewest)
+		default:
+			c = cmpBool(a.RecUsage(useAllocated) < b.RecUsage(useAllocated), a.RecUsage(useAllocated) > b.RecUsage(useAllocated))
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		return c
+	}
+}
+
+func (b *browser) enter(dir *scan.DirInfo, selectName string) {
+	b.cur = dir
+	b.showLargest = false
+	b.rows = slices.Clone(dir.Children)
+	slices.SortFunc(b.rows, cmpBrowse(b.sortBy))
+	b.sel, b.top = 0, 0
+	if selectName != "" {
+		if i := slices.IndexFunc(b.rows, func(d *scan.DirInfo) bool { return d.Name == selectName }); i >= 0 {
+			b.sel = i
+		}
+	}
+}
+
+func (b *browser) rowCount() int {
+	if b.showLargest {
+		return len(b.largest)
+	}
+	return len(b.rows)
+}
+
+// listHeight is the screen minus the 3 header lines and the status line
+func (b *browser) listHeight() int {
+	return max(b.height-4, 1)
+}
+
+func (b *browser) move(delta int) {
+	n := b.rowCount()
+	if n == 0 {
+		return
+	}
+	b.sel = min(max(b.sel+delta, 0), n-1)
+	if b.sel < b.top {
+		b.top = b.sel
+	} else if b.sel >= b.top+b.listHeight() {
+		b.top = b.sel - b.listHeight() + 1
+	}
+}
+
+func (b *browser) fit(s string) string {
+	if len(s) > b.width {
+		return s[:b.width]
+	}
+	return s
+}
+
+func (b *browser) line(s string, highlight bool) {
+	if highlight {
+		b.out.WriteString("\x1b[7m")
+	}
+	b.out.WriteString(b.fit(s))
+	if highlight {
+		b.out.WriteString("\x1b[0m")
+	}
+	b.out.WriteString("\x1b[K\r\n")
+}
+
+func (b *browser) draw() {
+	b.width, b.height, _ = term.GetSize(int(os.Stdout.Fd()))
+	if b.width <= 0 || b.height <= 0 {
+		b.width, b.height = 80, 24
+	}
+	b.out.WriteString("\x1b[H")
+	if b.showLargest {
+		b.line("Largest files (globally) - enter jumps to the directory, esc goes back", true)
+		b.line("", false)
+		b.line(fmt.Sprintf("%9s  %s", "Size", "Path"), false)
+		for i := b.top; i < b.top+b.listHeight(); i++ {
+			if i < len(b.largest) {
+				b.line(fmt.Sprintf("%9s  %s", statticker.FormatBytes(b.largest[i].Size), b.largest[i].Path), i == b.sel)
+			} else {
+				b.line("", false)
+			}
+		}
+	} else {
+		d := b.cur
+		b.line(fmt.Sprintf("%s   sort: %c  [s]ize [f]iles [d]irs [a]ge [L]argest [D]elete [T]rash [q]uit", d.Name, b.sortBy), true)
+		b.line(fmt.Sprintf("imm %s %s files %s dirs | rec %s %s files %s dirs | owner %s:%s | newest %s",
+			statticker.FormatBytes(d.ImmUsage(useAllocated)), statticker.AddCommas(d.ImmFiles), statticker.AddCommas(d.ImmDirs),
+			statticker.FormatBytes(d.RecUsage(useAllocated)), statticker.AddCommas(d.RecFiles), statticker.AddCommas(d.RecDirs),
+			ownerStr(d.Uid), groupStr(d.Gid), mod2str(d.RecNewest, &b.start)), false)
+		b.line(fmt.Sprintf("%9s %9s %12s %10s %12s %8s  %s", "RecSize", "ImmSize", "RecFiles", "RecDirs", "Newest", "Owner", "Name"), false)
+		for i := b.top; i < b.top+b.listHeight(); i++ {
+			if i < len(b.rows) {
+				c := b.rows[i]
+				b.line(fmt.Sprintf("%9s %9s %12s %10s %12s %8s  %s/",
+					statticker.FormatBytes(c.RecUsage(useAllocated)), statticker.FormatBytes(c.ImmUsage(useAllocated)),
+					statticker.AddCommas(c.RecFiles), statticker.AddCommas(c.RecDirs),
+					mod2str(c.RecNewest, &b.start), ownerStr(c.Uid), filepath.Base(c.Name)), i == b.sel)
+			} else {
+				b.line("", false)
+			}
+		}
+	}
+	b.out.WriteString(b.fit(b.status))
+	b.out.WriteString("\x1b[K")
+	b.out.Flush()
+	b.status = ""
+}
+
+func ownerStr(uid uint32) string {
+	if uid == scan.NullUserId {
+		return "?"
+	}
+	return idNames.User(uid)
+}
+
+func groupStr(gid uint32) string {
+	if gid == scan.NullUserId {
+		return "?"
+	}
+	return idNames.Group(gid)
+}
+
+// readKey turns escape sequences for the keys we care about into single
+// runes: arrows map to the vi keys and page up/down to ctrl-b/ctrl-f
+func readKey(in *bufio.Reader) (rune, error) {
+	r, _, err := in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+	if in.Buffered() == 0 {
+		return 0x1b, nil
+	}
+	if next, _ := in.ReadByte(); next != '[' && next != 'O' {
+		return 0x1b, nil
+	}
+	seq, _ := in.ReadByte()
+	switch seq {
+	case 'A':
+		return 'k', nil
+	case 'B':
+		return 'j', nil
+	case 'C':
+		return 'l', nil
+	case 'D':
+		return 'h', nil
+	case 'H':
+		return 'g', nil
+	case 'F':
+		return 'G', nil
+	case '5', '6':
+		in.ReadByte() // trailing ~
+		if seq == '5' {
+			return 0x02, nil
+		}
+		return 0x06, nil
+	}
+	return 0, nil
+}
+
+// browseTree is the interactive browser over a scanned tree
+func browseTree(res *scan.Result, cleanLog string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("browse mode needs a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	b := &browser{res: res, root: res.Root, sortBy: browseBySize, start: res.Start, out: bufio.NewWriter(os.Stdout), cleanLog: cleanLog}
+	b.largest = slices.Clone(res.LargestFiles)
+	b.enter(res.Root, "")
+
+	// alternate screen so the normal terminal contents come back on exit
+	b.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer func() {
+		b.out.WriteString("\x1b[?25h\x1b[?1049l")
+		b.out.Flush()
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		b.draw()
+		key, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		if b.pending != nil {
+			b.confirmCleanup(key == 'y' || key == 'Y')
+			continue
+		}
+		switch key {
+		case 'q', 0x03:
+			return nil
+		case 'j':
+			b.move(1)
+		case 'k':
+			b.move(-1)
+		case 0x06, ' ':
+			b.move(b.listHeight())
+		case 0x02:
+			b.move(-b.listHeight())
+		case 'g':
+			b.move(-b.rowCount())
+		case 'G':
+			b.move(b.rowCount())
+		case 'l', '\r', '\n':
+			if b.showLargest {
+				if len(b.largest) == 0 {
+					break
+				}
+				if dir := b.root.Find(filepath.Dir(b.largest[b.sel].Path)); dir != nil {
+					b.enter(dir, "")
+					b.status = "file: " + b.largest[b.sel].Path
+				} else {
+					b.status = "directory not in scanned tree"
+				}
+			} else if len(b.rows) > 0 {
+				b.enter(b.rows[b.sel], "")
+			}
+		case 'h', 0x7f, 0x08:
+			if b.showLargest {
+				b.enter(b.cur, "")
+			} else if b.cur.Parent != nil {
+				b.enter(b.cur.Parent, b.cur.Name)
+			}
+		case 0x1b:
+			if b.showLargest {
+				b.enter(b.cur, "")
+			}
+		case 'D':
+			b.previewCleanup(cleanDelete)
+		case 'T':
+			b.previewCleanup(cleanTrash)
+		case 'L':
+			b.showLargest = true
+			b.sel, b.top = 0, 0
+		case browseBySize, browseByFiles, browseByDirs, browseByAge:
+			if !b.showLargest {
+				b.sortBy = key
+				selected := ""
+				if len(b.rows) > 0 {
+					selected = b.rows[b.sel].Name
+				}
+				b.enter(b.cur, selected)
+				b.move(0)
+			}
+		}
+	}
+}
+
+func (b *browser) selectedPath() string {
+	if b.showLargest {
+		if b.sel < len(b.largest) {
+			return b.largest[b.sel].Path
+		}
+	} else if b.sel < len(b.rows) {
+		return b.rows[b.sel].Name
+	}
+	return ""
+}
+
+// previewCleanup is the dry run - nothing happens until confirmed
+func (b *browser) previewCleanup(action string) {
+	path := b.selectedPath()
+	if path == "" {
+		return
+	}
+	if a := archiveOf(b.res, path); a != "" {
+		b.status = fmt.Sprintf("cannot %s %s: archive %s is only read as a directory", action, path, a)
+		return
+	}
+	c, err := b.res.Measure(context.Background(), path)
+	if err != nil {
+		b.status = fmt.Sprintf("cannot %s %s: %v", action, path, err)
+		return
+	}
+	if c.Skipped > 0 {
+		b.status = fmt.Sprintf("cannot %s %s: %d entries below it were not scanned", action, path, c.Skipped)
+		return
+	}
+	b.pending, b.pendingAct = c, action
+	b.status = fmt.Sprintf("%s %s - %s in %s files and %s dirs? y/N", action, path,
+		statticker.FormatBytes(c.Totals.Size), statticker.AddCommas(c.Totals.Files), statticker.AddCommas(c.Totals.Dirs))
+}
+
+func (b *browser) confirmCleanup(yes bool) {
+	c, action := b.pending, b.pendingAct
+	b.pending = nil
+	if !yes {
+		b.status = action + " cancelled"
+		return
+	}
+	if err := applyCleanup(b.res, c, action, b.cleanLog); err != nil {
+		b.status = fmt.Sprintf("%s of %s failed: %v", action, c.Path, err)
+	} else {
+		b.status = fmt.Sprintf("%s of %s done, freed %s", action, c.Path, statticker.FormatBytes(c.Totals.Size))
+		b.largest = slices.DeleteFunc(b.largest, func(p scan.PathSize) bool {
+			return scan.Within(p.Path, c.Path)
+		})
+	}
+	sel := b.sel
+	if b.showLargest {
+		b.sel = min(sel, max(len(b.largest)-1, 0))
+	} else {
+		b.enter(b.cur, "")
+		b.move(sel)
+	}
+}
@@ -2,90 +2,14 @@ package main
 
 import (
 	"fmt"
-	"slices"
-	"sync/atomic"
+	"io"
+	"os"
+	"strings"
 
-	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sflanaga/du2go/scan"
 	"github.com/sflanaga/statticker"
 )
 
-const NULL_USER_ID = ^uint32(0)
-
-var switchUserCount uint64 = 0
-
-type UserStats struct {
-	uid       uint32
-	size      uint64
-	filecount uint64
-	dircount  uint64
-}
-
-func (user *UserStats) clear(uid uint32) {
-	user.uid = uid
-	user.dircount = 0
-	user.filecount = 0
-	user.size = 0
-}
-
-func switchUser(user *UserStats, uid uint32) {
-	if user.uid != NULL_USER_ID {
-		atomic.AddUint64(&switchUserCount, 1)
-	}
-	loadUserInfo(*user)
-	user.clear(uid)
-	// println("switch user: ", user.uid)
-}
-
-func (user *UserStats) addDir(uid uint32) {
-	if user.uid == NULL_USER_ID {
-		user.uid = uid
-	}
-	if uid == user.uid {
-		user.dircount += 1
-	} else {
-		switchUser(user, uid)
-	}
-}
-
-func (user *UserStats) addFile(uid uint32, size uint64) {
-	if user.uid == NULL_USER_ID {
-		user.uid = uid
-	}
-	if uid == user.uid {
-		user.filecount += 1
-		user.size += size
-		// fmt.Printf("acc user: %v\n", *user)
-	} else {
-		switchUser(user, uid)
-	}
-}
-
-var userMap = xsync.NewMapOf[uint32, UserStats]()
-
-func loadUserInfo(userInfo UserStats) {
-	if userInfo.uid == NULL_USER_ID {
-		// println("skipping user load>>>> ", userInfo.uid)
-		return
-	} else {
-		// fmt.Printf("loading user load>>>>, %v\n", userInfo)
-
-		userMap.Compute(userInfo.uid, func(oldValue UserStats, loaded bool) (newValue UserStats, delete bool) {
-			if !loaded {
-				// println("adding")
-				return userInfo, false
-			} else {
-				// println("updating")
-				oldValue.dircount += userInfo.dircount
-				oldValue.filecount += userInfo.filecount
-				oldValue.size += userInfo.size
-				return oldValue, false
-
-			}
-		})
-	}
-
-}
-
 func diffu64(a, b uint64) int64 {
 	var d int64
 	if a&0x8000_0000_0000_0000 > 0 || b&0x8000_0000_0000_0000 > 0 {
@@ -97,36 +21,104 @@ func diffu64(a, b uint64) int64 {
 	return d
 }
 
-func cmpUserStatsSort(i, j UserStats) int {
-	size_d := diffu64(i.size, j.size)
-	if size_d == 0 {
-		node_d := diffu64(i.filecount+i.dircount, j.filecount+j.dircount)
-		return -int(node_d)
-	} else {
-		return -int(size_d)
+func printUserInfo(res *scan.Result, limit int) {
+	if len(res.Users) > 0 {
+		fmt.Println("Total file usage by user id")
+		fmt.Printf("%-12s %6s  %8s %8s %8s   uniq users: %d, switch users: %d\n", "User", "UID", "Space", "Files", "Dirs", len(res.Users), res.UserSwitches)
+		for i, value := range res.UserList() {
+			if i >= limit {
+				break
+			}
+			fmt.Printf("%-12s %6d  %8s %8s %8s \n",
+				idNames.User(value.Uid),
+				value.Uid,
+				statticker.FormatBytes(value.Usage(useAllocated)),
+				statticker.AddCommas(value.Files),
+				statticker.AddCommas(value.Dirs))
+		}
+		fmt.Println()
 	}
 }
 
-func printUserInfo(limit int) {
-	if userMap.Size() > 0 {
-		fmt.Println("Total file usage by user id")
-		fmt.Printf("%6s  %8s %8s %8s   uniq users: %d, switch users: %d\n", "UID", "Space", "Files", "Dirs", userMap.Size(), switchUserCount)
-		var list = make([]UserStats, userMap.Size())
-		userMap.Range(func(key uint32, value UserStats) bool {
-			list = append(list, value)
-			return true
-		})
-		slices.SortFunc(list, cmpUserStatsSort)
-		for i, value := range list {
-			fmt.Printf("%6d  %8s %8s %8s \n",
-				value.uid,
-				statticker.FormatBytes(value.size),
-				statticker.AddCommas(value.filecount),
-				statticker.AddCommas(value.dircount))
+func printGroupInfo(res *scan.Result, limit int) {
+	if len(res.Groups) > 0 {
+		fmt.Println("Total file usage by group id")
+		fmt.Printf("%-12s %6s  %8s %8s %8s   uniq groups: %d\n", "Group", "GID", "Space", "Files", "Dirs", len(res.Groups))
+		for i, value := range res.GroupList() {
 			if i >= limit {
 				break
 			}
+			fmt.Printf("%-12s %6d  %8s %8s %8s \n",
+				idNames.Group(value.Gid),
+				value.Gid,
+				statticker.FormatBytes(value.Usage(useAllocated)),
+				statticker.AddCommas(value.Files),
+				statticker.AddCommas(value.Dirs))
 		}
 		fmt.Println()
 	}
 }
+
+// loadNames sets up idNames from the -passwd and -group files, which replace
+// the local user and group databases
+func loadNames(passwdFile, groupFile string) (*scan.Names, error) {
+	names := scan.NewNames()
+	for _, f := range []struct {
+		name string
+		load func(io.Reader) error
+	}{{passwdFile, names.LoadPasswd}, {groupFile, names.LoadGroup}} {
+		if f.name == "" {
+			continue
+		}
+		file, err := os.Open(f.name)
+		if err != nil {
+			return nil, err
+		}
+		err = f.load(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return names, nil
+}
+
+// printUserTops shows where each user's bytes are, biggest users first and at
+// most limit of them unless -users picked them
+func printUserTops(res *scan.Result, limit int, flatUnits bool) {
+	if len(res.Options.UserTopsFor) > 0 {
+		limit = len(res.Options.UserTopsFor)
+	}
+	shown := 0
+	for _, u := range res.UserList() {
+		top, ok := res.UserTops[u.Uid]
+		if !ok {
+			continue
+		}
+		if shown >= limit {
+			break
+		}
+		shown++
+		fmt.Printf("user %s (uid %d) owns %s in %s files\n", idNames.User(u.Uid), u.Uid,
+			statticker.FormatBytes(u.Usage(useAllocated)), statticker.AddCommas(u.Files))
+		printSummary(top.LargestFiles, true, "largest files", flatUnits)
+		printSummary(top.DirsByOwnedSize, true, "directories by the user's file size immediately in it", flatUnits)
+		fmt.Println()
+	}
+}
+
+// parseUserList takes a comma list of user names and uids for -users
+func parseUserList(list string) ([]uint32, error) {
+	var uids []uint32
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		uid, err := idNames.UserId(name)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sflanaga/du2go/scan"
+)
+
+// five owners of one file each, the larger the id the larger the file
+func ownersResult(t *testing.T) *scan.Result {
+	t.Helper()
+	fsys := scan.NewMemFS()
+	for id := range uint32(5) {
+		fsys.Add("/r/f"+string(rune('a'+id)), scan.MemFile{Size: int64(100 * (id + 1)), Uid: 100 + id, Gid: 100 + id})
+	}
+	return scanMemFS(t, fsys, scan.Options{})
+}
+
+// title and header then the three largest
+func checkOwnerReport(t *testing.T, out string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[2], "104 ") || !strings.HasPrefix(lines[4], "102 ") {
+		t.Errorf("report\n%s", out)
+	}
+}
+
+func TestPrintUserInfoLimit(t *testing.T) {
+	defer func(n *scan.Names) { idNames = n }(idNames)
+	idNames = nil
+	res := ownersResult(t)
+	checkOwnerReport(t, captureStdout(t, func() { printUserInfo(res, 3) }))
+}
+
+func TestPrintGroupInfoLimit(t *testing.T) {
+	defer func(n *scan.Names) { idNames = n }(idNames)
+	idNames = nil
+	res := ownersResult(t)
+	checkOwnerReport(t, captureStdout(t, func() { printGroupInfo(res, 3) }))
+}
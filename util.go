@@ -3,11 +3,14 @@ package main
 import (
 	"fmt"
 	"io/fs"
+	"maps"
 	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sflanaga/du2go/scan"
 	"github.com/sflanaga/statticker"
 )
 
@@ -17,31 +20,6 @@ func assert(condition bool, message string) {
 	}
 }
 
-func maxInt64(nums ...int64) int64 {
-	if len(nums) == 0 {
-		panic("No numbers provided") // Or handle this as needed
-	}
-	currentMax := nums[0]
-	for _, num := range nums[1:] {
-		if num > currentMax {
-			currentMax = num
-		}
-	}
-	return currentMax
-}
-func minInt64(nums ...int64) int64 {
-	if len(nums) == 0 {
-		panic("No numbers provided") // Or handle this as needed
-	}
-	currentMin := nums[0]
-	for _, num := range nums[1:] {
-		if num < currentMin {
-			currentMin = num
-		}
-	}
-	return currentMin
-}
-
 func formatDuration(d time.Duration, precision int) string {
 	if d == math.MaxInt64 {
 		return "NA"
@@ -139,35 +117,24 @@ func modeToStringLong(mode fs.FileMode) string {
 	return "unknown"
 }
 
-var filestatErrors uint64 = 0
-var notDirOrFile uint64 = 0
-var filterDirs uint64 = 0
-var dirListErrors uint64 = 0
-
-var countFileTypes = xsync.NewMapOf[fs.FileMode, int]()
-
-func printFilteredStringTypes(fileTypeMap *xsync.MapOf[fs.FileMode, int]) bool {
+func printFilteredStringTypes(fileTypes map[string]uint64) bool {
 	var list []string
-	var atLeastOne = false
-	fileTypeMap.Range(func(key fs.FileMode, value int) bool {
-		if value > 0 {
-			var s = fmt.Sprintf("%s=%d", modeToStringLong(key), value)
-			list = append(list, s)
-			atLeastOne = true
+	for _, name := range slices.Sorted(maps.Keys(fileTypes)) {
+		if fileTypes[name] > 0 {
+			list = append(list, fmt.Sprintf("%s=%d", name, fileTypes[name]))
 		}
-		return true // Continue iterating
-	})
-	if atLeastOne {
+	}
+	if len(list) > 0 {
 		fmt.Printf("count of \"off\" filetypes: %s\n", strings.Join(list, ", "))
 	}
-	return atLeastOne
+	return len(list) > 0
 }
 
 var tabs = string("                                                                                                    ")
 
-func treeWalk(dir *DirInfo, depth int) {
-	fmt.Printf("%9s %s %s %d\n", statticker.FormatBytes(dir.imm_size), tabs[0:depth], dir.name, depth)
-	for _, child := range dir.children {
+func treeWalk(dir *scan.DirInfo, depth int) {
+	fmt.Printf("%9s %s %s %d\n", statticker.FormatBytes(dir.ImmSize), tabs[0:depth], dir.Name, depth)
+	for _, child := range dir.Children {
 		treeWalk(child, depth+1)
 	}
 }
@@ -199,31 +166,48 @@ func mod2TimestampStr(filemod int64, now *time.Time) string {
 	}
 }
 
-func treeWalkDetails(dir *DirInfo, depth int, start *time.Time, flatUnits bool) {
-	if depth == 0 {
-		if flatUnits {
-			fmt.Printf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\n", "path", "imm_size", "imm_files", "imm_dirs",
-				"rec_size", "rec_files", "rec_dirs",
-				"imm_oldest_days", "imm_newest_days", "rec_oldest_days", "imm_oldest_days", "depth")
-		} else {
-			fmt.Printf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\n", "path", "imm_size", "imm_files", "imm_dirs",
-				"rec_size", "rec_files", "rec_dirs",
-				"imm_oldest", "imm_newest", "rec_oldest", "imm_oldest", "depth")
+// parseAge is time.ParseDuration plus d, w and y suffixes like formatDuration
+// prints.  An age option is off while it is 0 so an age must be above it.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("bad age '%s'", s)
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 24 * 7 * time.Hour, 'y': 24 * 365 * time.Hour}
+	var d time.Duration
+	if unit, ok := units[s[len(s)-1]]; len(s) > 1 && ok {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad age '%s'", s)
 		}
-	}
-	if !flatUnits {
-		fmt.Printf("%s,%s,%d,%d,%s,%d,%d,%s,%s,%s,%s,%d\n", dir.name, statticker.FormatBytes(dir.imm_size), dir.imm_files, dir.imm_dirs,
-			statticker.FormatBytes(dir.rec_size), dir.rec_files, dir.rec_dirs,
-			mod2str(dir.imm_old_file, start), mod2str(dir.imm_new_file, start), mod2str(dir.rec_old_file, start), mod2str(dir.rec_new_file, start),
-			depth)
+		d = time.Duration(n * float64(unit))
 	} else {
-		fmt.Printf("%s,%d,%d,%d,%d,%d,%d,%s,%s,%s,%s,%d\n", dir.name, dir.imm_size, dir.imm_files, dir.imm_dirs,
-			dir.rec_size, dir.rec_files, dir.rec_dirs,
-			mod2TimestampStr(dir.imm_old_file, start), mod2TimestampStr(dir.imm_new_file, start),
-			mod2TimestampStr(dir.rec_old_file, start), mod2TimestampStr(dir.rec_new_file, start),
-			depth)
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("age '%s' must be above 0", s)
+	}
+	return d, nil
+}
+
+// parseSize takes plain bytes or a K, M, G, T or P suffix in powers of 1024
+func parseSize(s string) (uint64, error) {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := uint64(1)
+	if i := strings.IndexAny(s, "KMGTP"); i >= 0 && i == len(s)-1 {
+		mult = 1 << (10 * (strings.IndexByte("KMGTP", s[i]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad size '%s'", s)
 	}
-	for _, child := range dir.children {
-		treeWalkDetails(child, depth+1, start, flatUnits)
+	// like ages a size option is off while it is 0
+	size := uint64(max(n*float64(mult), 0))
+	if size == 0 {
+		return 0, fmt.Errorf("size '%s' must be at least 1 byte", s)
 	}
+	return size, nil
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"90d": 90 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "1y": 365 * 24 * time.Hour,
+		"1.5d": 36 * time.Hour, "36h": 36 * time.Hour, "10m": 10 * time.Minute,
+	} {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("%q: %v %v want %v", in, got, err, want)
+		}
+	}
+	// an age of 0 or less would quietly turn the option off
+	for _, in := range []string{"", "d", "0d", "-5d", "-1h", "0", "0s", "5x", "xd"} {
+		if got, err := parseAge(in); err == nil {
+			t.Errorf("%q accepted as %v", in, got)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]uint64{
+		"1": 1, "1000": 1000, "1k": 1024, "1KB": 1024, "1.5M": 3 << 19, "2g": 2 << 30, " 1T ": 1 << 40, "1P": 1 << 50,
+	} {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("%q: %v %v want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "0K", "-1", "-5G", "0.1", "1X", "K"} {
+		if got, err := parseSize(in); err == nil {
+			t.Errorf("%q accepted as %v", in, got)
+		}
+	}
+	if _, err := parseQuotaLimit("0", false); err == nil {
+		t.Error("quota limit of 0 files accepted")
+	}
+	if n, err := parseQuotaLimit("-", false); err != nil || n != 0 {
+		t.Errorf("no limit %v %v", n, err)
+	}
+}